@@ -1,9 +1,13 @@
 package fastapi
 
 import (
+	"io"
 	"log"
+	"log/slog"
 	"net"
+	"os"
 	"runtime/debug"
+	"sync"
 	"time"
 
 	fastway "github.com/funny/fastway/go"
@@ -19,78 +23,275 @@ type Handler interface {
 
 type App struct {
 	serviceTypes []*ServiceType
-	services     [256]Provider
+	services     []Provider
 	timeRecoder  *pprof.TimeRecorder
+	stats        Stats
+	metrics      metrics
+	rateLimits   map[uint32]*RateLimit
+	auth         Authenticator
+	loginKey     uint32
+	accessRules  map[uint32]*AccessRule
+	sessions     sync.Map
+	requests     sync.Map
+	debugLog     int32
+	schemaHash   uint64
 
-	Pool         slab.Pool
-	ReadBufSize  int
+	Pool        slab.Pool
+	Header      HeaderFormat
+	ReadBufSize int
+	MaxRecvSize int
+	MaxSendSize int
+	SendTimeout time.Duration
+
+	// Outgoing packets are encoded by session.Send() and queued in codec,
+	// it's synchronized sending when SendChanSize is zero.
 	SendChanSize int
-	MaxRecvSize  int
-	MaxSendSize  int
-	RecvTimeout  time.Duration
-	SendTimeout  time.Duration
+
+	// SendPolicy decides what to do when the send queue of a session is full,
+	// OnSendOverflow is called in session.Send() when that happens.
+	SendPolicy     SendPolicy
+	OnSendOverflow func(*link.Session, SendPolicy)
+
+	// Queued packets are coalesced into one writev() up to SendBatchSize
+	// bytes, sendLoop waits SendBatchDelay at most for more packets before
+	// flushing. Zero SendBatchSize disables batching.
+	SendBatchSize  int
+	SendBatchDelay time.Duration
+
+	// Messages implemented NoCopyUnmarshaler reference the received packet
+	// when RetainRecvPacket is enabled. The packet is kept until the work of
+	// Handler.Transaction() returned, so the work can run in other goroutine,
+	// but the packet is never returned to Pool when the work isn't called.
+	// Sessions without handler keep it until the next session.Receive().
+	RetainRecvPacket bool
+
+	// SessionRateLimit limits the requests of all messages per session, see
+	// SetRateLimit() for the limit of each message.
+	SessionRateLimit *RateLimit
+
+	// Connections exceed MaxSessions, MaxSessionsPerIP or AcceptRate (per
+	// second, with AcceptBurst) are closed by the server once accepted.
+	MaxSessions      int
+	MaxSessionsPerIP int
+	AcceptRate       float64
+	AcceptBurst      int
+
+	// EnableMetrics records the request count, latency, errors and bytes of
+	// every message, see WriteMetrics() and MetricsHandler().
+	EnableMetrics  bool
+	MetricsBuckets []float64
+
+	// Logger logs every handled request when it's not nil, the requests take
+	// SlowRequest or longer are logged with details and payload dump.
+	Logger      *slog.Logger
+	SlowRequest time.Duration
+
+	// Recorder captures the packets of all sessions, see SetRecorder() for
+	// recording a single session.
+	Recorder *Recorder
+
+	// SpanExporter receives the spans of handled requests when the Header has
+	// trace fields.
+	SpanExporter SpanExporter
+
+	// SessionFactory creates the custom session type for the handlers like
+	// HandleRequest(session *MySession, req *MyRequest), the result is stored
+	// in link.Session.State before Handler.InitSession() is called.
+	SessionFactory func(*link.Session) interface{}
+
+	// OnAccessDenied is called when the request is denied by authentication or
+	// access rule, the session is closed unless it returns true.
+	OnAccessDenied func(*link.Session, Message, error) bool
+
+	// RecvTimeout is ignored when heartbeat is running on the session.
+	RecvTimeout time.Duration
+
+	// Features are offered in handshake, sessions without Handshake don't
+	// turn on any of them, so they talk to legacy peers.
+	Handshake        bool
+	HandshakeTimeout time.Duration
+	Features         Feature
+
+	// Messages of CompressThreshold bytes or larger are compressed by deflate
+	// when both sides enabled FeatureCompress, zero disables compression.
+	// Compression doesn't apply to the fragmented messages.
+	CompressThreshold int
+
+	// The session sends ping every HeartbeatInterval, and it's closed after
+	// HeartbeatMisses intervals without receiving anything from the remote.
+	// Heartbeat requires Handshake and FeatureHeartbeat on both sides.
+	// The pings are answered in Session.Receive(), a session handling a
+	// request in Handler.Transaction() synchronously doesn't answer, so the
+	// remote's HeartbeatInterval*HeartbeatMisses must be longer than the
+	// slowest request, or the remote closes the session.
+	HeartbeatInterval time.Duration
+	HeartbeatMisses   int
+
+	// Messages larger than MaxSendSize are split into fragments when both
+	// sides enabled FeatureFragment in handshake, MaxMessageSize limits the
+	// size of the whole message. Zero MaxMessageSize disables fragmentation,
+	// FeatureFragment is not offered in handshake then.
+	MaxMessageSize int
 }
 
 func New() *App {
 	return &App{
 		timeRecoder:  pprof.NewTimeRecorder(),
 		Pool:         &slab.NoPool{},
+		Header:       DefaultHeader,
 		ReadBufSize:  1024,
 		SendChanSize: 1024,
 		MaxRecvSize:  64 * 1024,
 		MaxSendSize:  64 * 1024,
+
+		HandshakeTimeout: 10 * time.Second,
+		Features:         FeatureHeartbeat | FeatureFragment | FeatureCompress,
+		HeartbeatMisses:  3,
+		MetricsBuckets:   DefaultMetricsBuckets,
 	}
 }
 
 func (app *App) handleSessoin(session *link.Session, handler Handler) {
 	defer session.Close()
 
+	bindSession(session)
+	app.addSession(session)
+	defer app.delSession(session)
+
+	if app.SessionFactory != nil {
+		session.State = app.SessionFactory(session)
+	}
+
 	if handler.InitSession(session) != nil {
 		return
 	}
 
+	limiter := app.newRateLimiter()
+
 	for {
 		msg, err := session.Receive()
 		if err != nil {
 			return
 		}
 
-		req := msg.(Message)
+		req, parent := recvTrace(session, msg.(Message))
+
+		if limiter != nil {
+			if limit := limiter.Check(req); limit != nil {
+				if !app.rateLimited(session, req, limit) {
+					return
+				}
+				ReleasePacket(session)
+				continue
+			}
+		}
+
+		packet := takePacket(session)
 		handler.Transaction(session, req, func() {
-			startTime := time.Now()
-			app.services[req.ServiceID()].(Service).HandleRequest(session, req)
-			app.timeRecoder.Record(req.Identity(), time.Since(startTime))
+			defer app.freePacket(packet)
+			if err := app.authorize(session, req); err != nil {
+				app.recordError(req)
+				app.logRequest(session, req, 0, "denied")
+				app.accessDenied(session, req, err)
+				return
+			}
+			app.handleRequest(session, req, parent)
 		})
 	}
 }
 
+// handleRequest invokes the service of authorized request, with logging,
+// tracing and metrics.
+func (app *App) handleRequest(session *link.Session, req Message, parent SpanContext) {
+	startTime := time.Now()
+	failed := true
+	span := app.startSpan(session, req, parent)
+	defer func() {
+		result := "ok"
+		if failed {
+			result = "panic"
+		}
+		app.logRequest(session, req, time.Since(startTime), result)
+		app.endSpan(session, span, failed)
+		app.recordRequest(req, time.Since(startTime), failed)
+	}()
+	app.service(req.ServiceID()).HandleRequest(session, req)
+	failed = false
+	app.timeRecoder.Record(req.Identity(), time.Since(startTime))
+}
+
 func (app *App) TimeRecoder() *pprof.TimeRecorder {
 	return app.timeRecoder
 }
 
-func (app *App) Dial(network, address string) (*link.Session, error) {
-	return link.Dial(network, address, link.ProtocolFunc(app.newClientCodec), app.SendChanSize)
+func (app *App) Dial(network, address string, opts ...CodecOption) (*link.Session, error) {
+	protocol := link.ProtocolFunc(func(rw io.ReadWriter) (link.Codec, error) {
+		return app.newClientCodec(rw, opts...)
+	})
+	session, err := link.Dial(network, address, protocol, 0)
+	if err != nil {
+		return nil, err
+	}
+	return bindSession(session), nil
 }
 
-func (app *App) Listen(network, address string, handler Handler) (*link.Server, error) {
+func (app *App) Listen(network, address string, handler Handler, opts ...CodecOption) (*link.Server, error) {
 	listener, err := net.Listen(network, address)
 	if err != nil {
 		return nil, err
 	}
-	return app.NewServer(listener, handler), nil
+	return app.NewServer(listener, handler, opts...), nil
+}
+
+func (app *App) DialUnix(path string, opts ...CodecOption) (*link.Session, error) {
+	return app.Dial("unix", path, opts...)
+}
+
+// ListenUnix removes the stale socket file left by a dead process before
+// listening, it fails when the socket is still accepted by another server.
+// The file is removed again when the server stopped.
+func (app *App) ListenUnix(path string, handler Handler, opts ...CodecOption) (*link.Server, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+		} else {
+			os.Remove(path)
+		}
+	}
+	return app.Listen("unix", path, handler, opts...)
 }
 
+// NewClient creates client session on the connection, it returns nil when
+// the handshake failed.
+//
+// Deprecated: use Connect(), which returns the handshake error.
 func (app *App) NewClient(conn net.Conn) *link.Session {
-	codec, _ := app.newClientCodec(conn)
-	return link.NewSession(codec, app.SendChanSize)
+	session, _ := app.Connect(conn)
+	return session
+}
+
+// Connect creates client session on the connection, the connection is closed
+// when the handshake failed.
+func (app *App) Connect(conn net.Conn, opts ...CodecOption) (*link.Session, error) {
+	codec, err := app.newClientCodec(conn, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return bindSession(link.NewSession(codec, 0)), nil
 }
 
-func (app *App) NewServer(listener net.Listener, handler Handler) *link.Server {
+func (app *App) NewServer(listener net.Listener, handler Handler, opts ...CodecOption) *link.Server {
 	if handler == nil {
 		handler = &noHandler{}
 	}
+	if app.needAdmission() {
+		listener = app.newAdmissionListener(listener)
+	}
+	protocol := link.ProtocolFunc(func(rw io.ReadWriter) (link.Codec, error) {
+		return app.newServerCodec(rw, opts...)
+	})
 	return link.NewServer(
-		listener, link.ProtocolFunc(app.newServerCodec), app.SendChanSize,
+		listener, protocol, 0,
 		link.HandlerFunc(func(session *link.Session) {
 			app.handleSessoin(session, handler)
 		}),
@@ -98,12 +299,12 @@ func (app *App) NewServer(listener net.Listener, handler Handler) *link.Server {
 }
 
 func (app *App) NewFastwayClient(conn net.Conn, cfg fastway.EndPointCfg) *fastway.EndPoint {
-	cfg.MsgFormat = &msgFormat{app.newResponse}
+	cfg.MsgFormat = &msgFormat{app, app.newResponse}
 	return fastway.NewClient(conn, cfg)
 }
 
 func (app *App) NewFastwayServer(conn net.Conn, cfg fastway.EndPointCfg, handler Handler) (*FastwayServer, error) {
-	cfg.MsgFormat = &msgFormat{app.newRequest}
+	cfg.MsgFormat = &msgFormat{app, app.newRequest}
 	endpoint, err := fastway.NewServer(conn, cfg)
 	if err != nil {
 		return nil, err
@@ -126,8 +327,16 @@ func (s *FastwayServer) Serve() error {
 		if err != nil {
 			return err
 		}
-		go s.app.handleSessoin(session, s.handler)
+		go s.handleSession(session)
+	}
+}
+
+func (s *FastwayServer) handleSession(session *link.Session) {
+	if s.app.SendChanSize > 0 {
+		sender := s.app.newFastwaySender(session)
+		defer sender.Close()
 	}
+	s.app.handleSessoin(session, s.handler)
 }
 
 func (s *FastwayServer) GetSession(sessionID uint64) *link.Session {
@@ -152,6 +361,7 @@ func (t *noHandler) Transaction(session *link.Session, req Message, work func())
 	defer func() {
 		if err := recover(); err != nil {
 			log.Printf("fastapi: unhandled panic when processing '%s' - '%s'", req.Identity(), err)
+			log.Printf("fastapi: request %s", DumpMessage(req))
 			log.Println(string(debug.Stack()))
 		}
 	}()
@@ -0,0 +1,174 @@
+package fastapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/funny/link"
+)
+
+// SetDebugLog makes the Logger log every request at info level, so they're
+// visible without changing the level of the log handler.
+func (app *App) SetDebugLog(enable bool) {
+	var v int32
+	if enable {
+		v = 1
+	}
+	atomic.StoreInt32(&app.debugLog, v)
+}
+
+func (app *App) DebugLog() bool {
+	return atomic.LoadInt32(&app.debugLog) == 1
+}
+
+// Session returns the active session of the App by session ID.
+func (app *App) Session(sessionID uint64) *link.Session {
+	var found *link.Session
+	app.sessions.Range(func(key, _ interface{}) bool {
+		if session := key.(*link.Session); session.ID() == sessionID {
+			found = session
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+type adminMessage struct {
+	ID       uint16   `json:"id"`
+	Name     string   `json:"name"`
+	Priority Priority `json:"priority,omitempty"`
+}
+
+type adminService struct {
+	ID        uint16         `json:"id"`
+	Name      string         `json:"name"`
+	Package   string         `json:"package"`
+	Requests  []adminMessage `json:"requests"`
+	Responses []adminMessage `json:"responses"`
+}
+
+type adminSession struct {
+	ID         uint64 `json:"id"`
+	Remote     string `json:"remote"`
+	QueueDepth int    `json:"queue_depth"`
+	RTT        string `json:"rtt,omitempty"`
+	Identity   string `json:"identity,omitempty"`
+}
+
+// AdminHandler serves the runtime introspection of the App:
+//
+//	GET  /services              registered services and messages
+//	GET  /sessions              active sessions
+//	GET  /time                  TimeRecoder() in CSV
+//	GET  /metrics               WriteMetrics()
+//	POST /kick?session=<id>     close the session
+//	POST /debug?enable=<bool>   SetDebugLog()
+//
+// Mount it with http.StripPrefix() when it's not served at root, and don't
+// expose it to the public network.
+func (app *App) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services", app.adminServices)
+	mux.HandleFunc("/sessions", app.adminSessions)
+	mux.HandleFunc("/time", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		app.timeRecoder.WriteCSV(w)
+	})
+	mux.Handle("/metrics", app.MetricsHandler())
+	mux.HandleFunc("/kick", app.adminKick)
+	mux.HandleFunc("/debug", app.adminDebug)
+	return mux
+}
+
+func (app *App) adminServices(w http.ResponseWriter, r *http.Request) {
+	services := []adminService{}
+	for _, serviceType := range app.serviceTypes {
+		service := adminService{
+			ID:        serviceType.ID(),
+			Name:      serviceType.Name(),
+			Package:   serviceType.Type().PkgPath(),
+			Requests:  adminMessages(serviceType.Requests()),
+			Responses: adminMessages(serviceType.Responses()),
+		}
+		services = append(services, service)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].ID < services[j].ID
+	})
+	writeJSON(w, services)
+}
+
+func adminMessages(types []*MessageType) []adminMessage {
+	messages := []adminMessage{}
+	for _, t := range types {
+		messages = append(messages, adminMessage{t.ID(), t.Name(), t.Priority()})
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].ID < messages[j].ID
+	})
+	return messages
+}
+
+func (app *App) adminSessions(w http.ResponseWriter, r *http.Request) {
+	sessions := []adminSession{}
+	app.sessions.Range(func(key, _ interface{}) bool {
+		session := key.(*link.Session)
+		info := adminSession{
+			ID:         session.ID(),
+			Remote:     remoteAddr(session),
+			QueueDepth: QueueDepth(session),
+		}
+		if rtt := RTT(session); rtt > 0 {
+			info.RTT = rtt.String()
+		}
+		if identity := app.Identity(session); identity != nil {
+			info.Identity = identity.Name
+		}
+		sessions = append(sessions, info)
+		return true
+	})
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ID < sessions[j].ID
+	})
+	writeJSON(w, sessions)
+}
+
+func (app *App) adminKick(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sessionID, err := strconv.ParseUint(r.FormValue("session"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad session id", http.StatusBadRequest)
+		return
+	}
+	session := app.Session(sessionID)
+	if session == nil {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+	session.Close()
+	writeJSON(w, map[string]uint64{"kicked": sessionID})
+}
+
+func (app *App) adminDebug(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		enable, err := strconv.ParseBool(r.FormValue("enable"))
+		if err != nil {
+			http.Error(w, "bad enable value", http.StatusBadRequest)
+			return
+		}
+		app.SetDebugLog(enable)
+	}
+	writeJSON(w, map[string]bool{"debug": app.DebugLog()})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
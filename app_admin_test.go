@@ -0,0 +1,122 @@
+package fastapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testAdminRequest(t *testing.T, handler http.Handler, method, url string, code int, v interface{}) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+	if w.Code != code {
+		t.Fatalf("%s %s: status %d, expected %d", method, url, w.Code, code)
+	}
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: %v", method, url, err)
+		}
+	}
+}
+
+func TestAdminServices(t *testing.T) {
+	app := newTestApp()
+	var services []adminService
+	testAdminRequest(t, app.AdminHandler(), "GET", "/services", http.StatusOK, &services)
+	if len(services) != 1 {
+		t.Fatalf("unexpected services: %+v", services)
+	}
+	service := services[0]
+	if service.ID != 1 || service.Name != "testService" || service.Package != "github.com/funny/fastapi" {
+		t.Fatalf("unexpected service: %+v", service)
+	}
+	if len(service.Requests) != 1 || service.Requests[0].ID != 1 || service.Requests[0].Name != "testEcho" ||
+		len(service.Responses) != 1 || service.Responses[0].ID != 1 {
+		t.Fatalf("unexpected messages: %+v", service)
+	}
+}
+
+func TestAdminSessions(t *testing.T) {
+	app := newTestApp()
+	handler := app.AdminHandler()
+	listener := ListenPipe()
+	go app.NewServer(listener, nil).Serve()
+	defer listener.Close()
+
+	session, err := newTestApp().DialPipe(listener)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	testRoundTrip(t, session, []byte("hello"))
+
+	var sessions []adminSession
+	testAdminRequest(t, handler, "GET", "/sessions", http.StatusOK, &sessions)
+	if len(sessions) != 1 {
+		t.Fatalf("unexpected sessions: %+v", sessions)
+	}
+	id := strconv.FormatUint(sessions[0].ID, 10)
+
+	testAdminRequest(t, handler, "GET", "/kick?session="+id, http.StatusMethodNotAllowed, nil)
+	testAdminRequest(t, handler, "POST", "/kick?session=x", http.StatusBadRequest, nil)
+	testAdminRequest(t, handler, "POST", "/kick?session=999999", http.StatusNotFound, nil)
+
+	var kicked map[string]uint64
+	testAdminRequest(t, handler, "POST", "/kick?session="+id, http.StatusOK, &kicked)
+	if kicked["kicked"] != sessions[0].ID {
+		t.Fatalf("unexpected result: %v", kicked)
+	}
+	if _, err := session.Receive(); err == nil {
+		t.Fatal("kicked session not closed")
+	}
+	for deadline := time.Now().Add(time.Second); ; {
+		testAdminRequest(t, handler, "GET", "/sessions", http.StatusOK, &sessions)
+		if len(sessions) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("kicked session still listed: %+v", sessions)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAdminDebug(t *testing.T) {
+	app := newTestApp()
+	handler := app.AdminHandler()
+	var debug map[string]bool
+	testAdminRequest(t, handler, "POST", "/debug?enable=true", http.StatusOK, &debug)
+	if !debug["debug"] || !app.DebugLog() {
+		t.Fatal("debug log not enabled")
+	}
+	testAdminRequest(t, handler, "GET", "/debug", http.StatusOK, &debug)
+	if !debug["debug"] {
+		t.Fatal("debug log not reported")
+	}
+	testAdminRequest(t, handler, "POST", "/debug?enable=x", http.StatusBadRequest, nil)
+	testAdminRequest(t, handler, "POST", "/debug?enable=false", http.StatusOK, &debug)
+	if debug["debug"] || app.DebugLog() {
+		t.Fatal("debug log not disabled")
+	}
+}
+
+func TestAdminMetrics(t *testing.T) {
+	app := newTestApp()
+	w := httptest.NewRecorder()
+	app.AdminHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") ||
+		!strings.Contains(w.Body.String(), "fastapi_sessions 0\n") {
+		t.Fatalf("unexpected metrics: %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	app.AdminHandler().ServeHTTP(w, httptest.NewRequest("GET", "/time", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/csv" {
+		t.Fatalf("unexpected time: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+}
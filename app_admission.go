@@ -0,0 +1,119 @@
+package fastapi
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// admissionListener closes the connections exceed the limits of App before
+// they reach link.Server, so no codec or session is allocated for them.
+type admissionListener struct {
+	net.Listener
+	app      *App
+	mutex    sync.Mutex
+	sessions int
+	perIP    map[string]int
+	rate     RateLimit
+	bucket   tokenBucket
+}
+
+func (app *App) needAdmission() bool {
+	return app.MaxSessions > 0 || app.MaxSessionsPerIP > 0 || app.AcceptRate > 0
+}
+
+func (app *App) newAdmissionListener(listener net.Listener) *admissionListener {
+	burst := app.AcceptBurst
+	if burst <= 0 {
+		burst = 1
+	}
+	return &admissionListener{
+		Listener: listener,
+		app:      app,
+		perIP:    make(map[string]int),
+		rate:     RateLimit{Rate: app.AcceptRate, Burst: burst},
+	}
+}
+
+func (l *admissionListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := remoteIP(conn)
+		if l.admit(ip) {
+			return &admittedConn{Conn: conn, listener: l, ip: ip}, nil
+		}
+		conn.Close()
+	}
+}
+
+func (l *admissionListener) admit(ip string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// The accept token is taken after all the limits passed, so the rejected
+	// connections don't throttle others.
+	if l.app.MaxSessions > 0 && l.sessions >= l.app.MaxSessions {
+		atomic.AddUint64(&l.app.stats.RejectedMaxSessions, 1)
+		return false
+	}
+	if l.app.MaxSessionsPerIP > 0 && ip != "" && l.perIP[ip] >= l.app.MaxSessionsPerIP {
+		atomic.AddUint64(&l.app.stats.RejectedPerIP, 1)
+		return false
+	}
+	if l.app.AcceptRate > 0 {
+		if !l.bucket.Allow(&l.rate, time.Now()) {
+			atomic.AddUint64(&l.app.stats.RejectedAcceptRate, 1)
+			return false
+		}
+		l.bucket.tokens--
+	}
+
+	l.sessions++
+	if ip != "" {
+		l.perIP[ip]++
+	}
+	return true
+}
+
+func (l *admissionListener) release(ip string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.sessions--
+	if ip != "" {
+		if l.perIP[ip]--; l.perIP[ip] <= 0 {
+			delete(l.perIP, ip)
+		}
+	}
+}
+
+func remoteIP(conn net.Conn) string {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
+}
+
+// admittedConn gives back the quota when it's closed. The codec unwraps it,
+// so writev() still works on the underlying *net.TCPConn.
+type admittedConn struct {
+	net.Conn
+	listener *admissionListener
+	ip       string
+	once     sync.Once
+}
+
+func (c *admittedConn) release() {
+	c.once.Do(func() {
+		c.listener.release(c.ip)
+	})
+}
+
+func (c *admittedConn) Close() error {
+	c.release()
+	return c.Conn.Close()
+}
@@ -0,0 +1,137 @@
+package fastapi
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// ipConn is the connection from the remote IP.
+type ipConn struct {
+	net.Conn
+	ip string
+}
+
+func (c *ipConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(c.ip), Port: 10000}
+}
+
+// testListener is the listener admit() is called directly.
+type testListener struct{}
+
+func (l *testListener) Accept() (net.Conn, error) {
+	return nil, io.EOF
+}
+
+func (l *testListener) Close() error   { return nil }
+func (l *testListener) Addr() net.Addr { return pipeAddr{} }
+
+// testAdmit returns the admitted connection from the IP, or nil when it's
+// rejected.
+func testAdmit(l *admissionListener, ip string) net.Conn {
+	local, remote := Pipe()
+	remote.Close()
+	if l.admit(ip) {
+		return &admittedConn{Conn: &ipConn{local, ip}, listener: l, ip: ip}
+	}
+	local.Close()
+	return nil
+}
+
+func TestAdmissionOrder(t *testing.T) {
+	app := New()
+	app.MaxSessions = 1
+	app.AcceptRate = 0.001
+	app.AcceptBurst = 2
+	l := app.newAdmissionListener(&testListener{})
+
+	a := testAdmit(l, "10.0.0.1")
+	if a == nil {
+		t.Fatal("first connection rejected")
+	}
+
+	// Rejected by MaxSessions without taking the accept token.
+	if testAdmit(l, "10.0.0.2") != nil {
+		t.Fatal("MaxSessions exceeded")
+	}
+	a.Close()
+	b := testAdmit(l, "10.0.0.2")
+	if b == nil {
+		t.Fatal("connection rejected after release")
+	}
+	b.Close()
+
+	if testAdmit(l, "10.0.0.3") != nil {
+		t.Fatal("AcceptRate exceeded")
+	}
+	stats := app.Stats()
+	if stats.RejectedMaxSessions != 1 || stats.RejectedAcceptRate != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestAdmissionRelease(t *testing.T) {
+	app := New()
+	app.MaxSessions = 3
+	app.MaxSessionsPerIP = 1
+	l := app.newAdmissionListener(&testListener{})
+
+	a := testAdmit(l, "10.0.0.1")
+	if a == nil || testAdmit(l, "10.0.0.1") != nil {
+		t.Fatal("MaxSessionsPerIP not applied")
+	}
+	b := testAdmit(l, "10.0.0.2")
+	if b == nil {
+		t.Fatal("another IP rejected")
+	}
+	if l.sessions != 2 || l.perIP["10.0.0.1"] != 1 {
+		t.Fatalf("sessions %d, per IP %v", l.sessions, l.perIP)
+	}
+
+	// The quota is given back once.
+	a.Close()
+	a.Close()
+	if l.sessions != 1 || len(l.perIP) != 1 {
+		t.Fatalf("sessions %d, per IP %v", l.sessions, l.perIP)
+	}
+	if testAdmit(l, "10.0.0.1") == nil {
+		t.Fatal("connection rejected after release")
+	}
+	if n := app.Stats().RejectedPerIP; n != 1 {
+		t.Fatalf("RejectedPerIP = %d", n)
+	}
+}
+
+func TestAdmissionServer(t *testing.T) {
+	app := newTestApp()
+	app.Handshake = true
+	app.MaxSessions = 1
+	listener := ListenPipe()
+	go app.NewServer(listener, nil).Serve()
+	defer listener.Close()
+
+	session, err := app.DialPipe(listener)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRoundTrip(t, session, []byte("hello"))
+	if _, err := app.DialPipe(listener); err == nil {
+		t.Fatal("MaxSessions exceeded")
+	}
+
+	// The server session gives back the quota when it's closed.
+	session.Close()
+	for i := 0; ; i++ {
+		session, err = app.DialPipe(listener)
+		if err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatal("quota not released")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	defer session.Close()
+	testRoundTrip(t, session, []byte("hello"))
+}
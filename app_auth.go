@@ -0,0 +1,160 @@
+package fastapi
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/funny/link"
+)
+
+// Identity is attached to the session by the Authenticator.
+type Identity struct {
+	Name  string
+	Roles []string
+	Data  interface{}
+}
+
+func (identity *Identity) HasRole(role string) bool {
+	for _, r := range identity.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator is invoked on the login message before the login request is
+// handled by the service. The returned identity is attached to the session,
+// the session is denied when error returned.
+type Authenticator interface {
+	Authenticate(session *link.Session, req Message) (*Identity, error)
+}
+
+type AuthenticatorFunc func(*link.Session, Message) (*Identity, error)
+
+func (f AuthenticatorFunc) Authenticate(session *link.Session, req Message) (*Identity, error) {
+	return f(session, req)
+}
+
+type AccessRule struct {
+	login bool
+	roles []string
+}
+
+var (
+	AllowAnonymous = &AccessRule{}
+	RequireLogin   = &AccessRule{login: true}
+)
+
+// RequireRole allows the identities have any of the roles.
+func RequireRole(roles ...string) *AccessRule {
+	return &AccessRule{login: true, roles: roles}
+}
+
+func (rule *AccessRule) Allow(identity *Identity) bool {
+	if !rule.login {
+		return true
+	}
+	if identity == nil {
+		return false
+	}
+	if len(rule.roles) == 0 {
+		return true
+	}
+	for _, role := range rule.roles {
+		if identity.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAccess declares the access rule of a request type in APIs.
+func SetAccess(rule *AccessRule) MessageOption {
+	return func(msg *MessageType) {
+		msg.access = rule
+	}
+}
+
+// SetServiceAccess is the access rule of the service requests don't declare
+// their own rule.
+func SetServiceAccess(rule *AccessRule) ServiceOption {
+	return func(service *ServiceType) {
+		service.access = rule
+	}
+}
+
+type AccessError struct {
+	Identity string
+	Reason   string
+}
+
+func (accessError AccessError) Error() string {
+	return fmt.Sprintf("Access Denied: '%s' - %s", accessError.Identity, accessError.Reason)
+}
+
+// SetAuthenticator enables authentication, the authenticator is invoked on
+// the login message. Requests without access rule require login when
+// authentication is enabled.
+func (app *App) SetAuthenticator(serviceID, loginMessageID uint16, auth Authenticator) {
+	app.auth = auth
+	app.loginKey = uint32(serviceID)<<16 | uint32(loginMessageID)
+}
+
+func (app *App) Identity(session *link.Session) *Identity {
+	if info := app.sessionInfo(session); info != nil {
+		info.mutex.Lock()
+		defer info.mutex.Unlock()
+		return info.identity
+	}
+	return nil
+}
+
+// SetIdentity replaces the identity of session, set nil to logout.
+func (app *App) SetIdentity(session *link.Session, identity *Identity) {
+	if info := app.sessionInfo(session); info != nil {
+		info.mutex.Lock()
+		defer info.mutex.Unlock()
+		info.identity = identity
+	}
+}
+
+// authorize returns nil when the request can be handled.
+func (app *App) authorize(session *link.Session, req Message) error {
+	key := uint32(req.ServiceID())<<16 | uint32(req.MessageID())
+
+	if app.auth != nil && key == app.loginKey {
+		identity, err := app.auth.Authenticate(session, req)
+		if err != nil {
+			atomic.AddUint64(&app.stats.AuthFailures, 1)
+			return AccessError{req.Identity(), err.Error()}
+		}
+		app.SetIdentity(session, identity)
+		return nil
+	}
+
+	rule := app.accessRules[key]
+	if rule == nil {
+		if app.auth == nil {
+			return nil
+		}
+		rule = RequireLogin
+	}
+
+	identity := app.Identity(session)
+	if rule.Allow(identity) {
+		return nil
+	}
+
+	atomic.AddUint64(&app.stats.AccessDenied, 1)
+	if identity == nil {
+		return AccessError{req.Identity(), "Login Required"}
+	}
+	return AccessError{req.Identity(), fmt.Sprintf("Permission Denied For '%s'", identity.Name)}
+}
+
+func (app *App) accessDenied(session *link.Session, req Message, err error) {
+	if app.OnAccessDenied == nil || !app.OnAccessDenied(session, req, err) {
+		session.Close()
+	}
+}
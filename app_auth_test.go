@@ -0,0 +1,182 @@
+package fastapi
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+)
+
+type testAuthService struct{}
+
+func (s *testAuthService) APIs() APIs {
+	return APIs{
+		1: {testLogin{}, testLogin{}},
+		2: {testAction{}, testAction{}},
+		3: {With(testAdminAction{}, SetAccess(RequireRole("admin"))), testAdminAction{}},
+		4: {With(testPublic{}, SetAccess(AllowAnonymous)), testPublic{}},
+	}
+}
+
+func (s *testAuthService) ServiceID() uint16 {
+	return 4
+}
+
+func (s *testAuthService) NewRequest(id uint16) Message {
+	switch id {
+	case 1:
+		return &testLogin{}
+	case 2:
+		return &testAction{}
+	case 3:
+		return &testAdminAction{}
+	case 4:
+		return &testPublic{}
+	}
+	return nil
+}
+
+func (s *testAuthService) NewResponse(id uint16) Message {
+	return s.NewRequest(id)
+}
+
+func (s *testAuthService) HandleRequest(session *link.Session, req Message) {
+	Send(session, req)
+}
+
+type testLogin struct{ testEcho }
+type testAction struct{ testEcho }
+type testAdminAction struct{ testEcho }
+type testPublic struct{ testEcho }
+
+func (m *testLogin) ServiceID() uint16       { return 4 }
+func (m *testAction) ServiceID() uint16      { return 4 }
+func (m *testAdminAction) ServiceID() uint16 { return 4 }
+func (m *testPublic) ServiceID() uint16      { return 4 }
+
+func (m *testLogin) MessageID() uint16       { return 1 }
+func (m *testAction) MessageID() uint16      { return 2 }
+func (m *testAdminAction) MessageID() uint16 { return 3 }
+func (m *testPublic) MessageID() uint16      { return 4 }
+
+func (m *testLogin) Identity() string       { return "testAuthService.testLogin" }
+func (m *testAction) Identity() string      { return "testAuthService.testAction" }
+func (m *testAdminAction) Identity() string { return "testAuthService.testAdminAction" }
+func (m *testPublic) Identity() string      { return "testAuthService.testPublic" }
+
+func newAuthApp() *App {
+	app := New()
+	app.Register(4, &testAuthService{})
+	app.SetAuthenticator(4, 1, AuthenticatorFunc(func(session *link.Session, req Message) (*Identity, error) {
+		switch name := string(req.(*testLogin).Data); name {
+		case "bob":
+			return &Identity{Name: name}, nil
+		case "root":
+			return &Identity{Name: name, Roles: []string{"admin"}}, nil
+		}
+		return nil, errors.New("bad password")
+	}))
+	return app
+}
+
+func testAllowed(t *testing.T, session *link.Session, req Message) {
+	t.Helper()
+	if err := session.Send(req); err != nil {
+		t.Fatal(err)
+	}
+	rsp, err := session.Receive()
+	if err != nil {
+		t.Fatalf("%s denied: %v", req.Identity(), err)
+	}
+	if rsp.(Message).Identity() != req.Identity() {
+		t.Fatalf("unexpected response %s", rsp.(Message).Identity())
+	}
+}
+
+func testDenied(t *testing.T, session *link.Session, denied chan error, req Message, reason string) {
+	t.Helper()
+	if err := session.Send(req); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-denied:
+		if _, ok := err.(AccessError); !ok || !strings.Contains(err.Error(), reason) {
+			t.Fatalf("%s: unexpected error %v", req.Identity(), err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("%s not denied", req.Identity())
+	}
+}
+
+func TestAuth(t *testing.T) {
+	app := newAuthApp()
+	denied := make(chan error, 1)
+	app.OnAccessDenied = func(session *link.Session, req Message, err error) bool {
+		denied <- err
+		return true
+	}
+	session, err := app.Pipe(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	testAllowed(t, session, &testPublic{})
+	testDenied(t, session, denied, &testAction{}, "Login Required")
+	testDenied(t, session, denied, &testLogin{testEcho{[]byte("eve")}}, "bad password")
+
+	testAllowed(t, session, &testLogin{testEcho{[]byte("bob")}})
+	testAllowed(t, session, &testAction{})
+	testDenied(t, session, denied, &testAdminAction{}, "Permission Denied For 'bob'")
+
+	testAllowed(t, session, &testLogin{testEcho{[]byte("root")}})
+	testAllowed(t, session, &testAdminAction{})
+
+	stats := app.Stats()
+	if stats.AuthFailures != 1 || stats.AccessDenied != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestAuthDeniedClose(t *testing.T) {
+	for _, onDenied := range []func(*link.Session, Message, error) bool{
+		nil,
+		func(*link.Session, Message, error) bool { return false },
+	} {
+		app := newAuthApp()
+		app.OnAccessDenied = onDenied
+		session, err := app.Pipe(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := session.Send(&testAction{}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := session.Receive(); err == nil {
+			t.Fatal("denied session not closed")
+		}
+		session.Close()
+	}
+}
+
+func TestAccessRule(t *testing.T) {
+	bob := &Identity{Name: "bob", Roles: []string{"user"}}
+	for _, c := range []struct {
+		rule     *AccessRule
+		identity *Identity
+		allowed  bool
+	}{
+		{AllowAnonymous, nil, true},
+		{RequireLogin, nil, false},
+		{RequireLogin, bob, true},
+		{RequireRole("admin", "user"), bob, true},
+		{RequireRole("admin"), bob, false},
+		{RequireRole("admin"), nil, false},
+	} {
+		if allowed := c.rule.Allow(c.identity); allowed != c.allowed {
+			t.Fatalf("%+v allows %+v: %v", c.rule, c.identity, allowed)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package fastapi
+
+import (
+	"sync/atomic"
+
+	"github.com/funny/link"
+)
+
+type SendPolicy int
+
+const (
+	// SendDisconnect closes the session, it's the default policy.
+	SendDisconnect SendPolicy = iota
+
+	// SendBlock blocks session.Send() until the queue has room or the
+	// session closed.
+	SendBlock
+
+	// SendDropOldest drops the oldest packets of the lowest priority to make
+	// room, the packet being sent is dropped when there are only packets of
+	// higher priority in queue.
+	SendDropOldest
+
+	// SendDropNewest drops the packet being sent.
+	SendDropNewest
+)
+
+func (c *codec) overflow(level int, packet []byte) error {
+	return c.app.overflow(c.session, c.queue, c.closeChan, level, packet, c.app.Pool.Free, func() { c.Close() })
+}
+
+// overflow applies the SendPolicy when the queue is full, free releases the
+// dropped packets and close closes the session.
+func (app *App) overflow(session *link.Session, queue *sendQueue, closeChan chan struct{}, level int, packet []byte, free func([]byte), close func()) error {
+	policy := app.SendPolicy
+	if app.OnSendOverflow != nil && session != nil {
+		app.OnSendOverflow(session, policy)
+	}
+
+	switch policy {
+	case SendBlock:
+		atomic.AddUint64(&app.stats.SendBlocked, 1)
+		for !queue.Push(level, packet) {
+			select {
+			case <-queue.space:
+			case <-closeChan:
+				free(packet)
+				return link.SessionClosedError
+			}
+		}
+		return nil
+	case SendDropOldest:
+		for !queue.Push(level, packet) {
+			atomic.AddUint64(&app.stats.SendDropped, 1)
+			old := queue.DropOldest(level)
+			if old == nil {
+				free(packet)
+				return nil
+			}
+			free(old)
+		}
+		return nil
+	case SendDropNewest:
+		atomic.AddUint64(&app.stats.SendDropped, 1)
+		free(packet)
+		return nil
+	}
+
+	atomic.AddUint64(&app.stats.SendKicked, 1)
+	free(packet)
+	close()
+	return link.SessionBlockedError
+}
+
+// QueueDepth returns the number of packets waiting in the send queue.
+func QueueDepth(session *link.Session) int {
+	if c, ok := session.Codec().(*codec); ok && c.queue != nil {
+		return c.queue.Len()
+	}
+	if s, ok := fastwaySenders.Load(session); ok {
+		return s.(*fastwaySender).queue.Len()
+	}
+	return 0
+}
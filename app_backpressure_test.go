@@ -0,0 +1,177 @@
+package fastapi
+
+import (
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+)
+
+// gateConn blocks writing until the gate opened.
+type gateConn struct {
+	net.Conn
+	gate chan struct{}
+}
+
+func (c *gateConn) Write(p []byte) (int, error) {
+	<-c.gate
+	return c.Conn.Write(p)
+}
+
+// newBlockedSession returns a session has the first packet blocked in
+// writing and the queue of 2 packets full.
+func newBlockedSession(t *testing.T, policy SendPolicy) (*App, *link.Session, *gateConn, net.Conn, *[]SendPolicy) {
+	t.Helper()
+	app := newTestApp()
+	app.SendChanSize = 2
+	app.SendPolicy = policy
+	overflows := &[]SendPolicy{}
+	app.OnSendOverflow = func(session *link.Session, policy SendPolicy) {
+		*overflows = append(*overflows, policy)
+	}
+
+	local, remote := Pipe()
+	conn := &gateConn{local, make(chan struct{})}
+	session, err := app.Connect(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Send(&testEcho{[]byte("1")})
+	for QueueDepth(session) != 0 {
+		runtime.Gosched()
+	}
+	session.Send(&testEcho{[]byte("2")})
+	session.Send(&testEcho{[]byte("3")})
+	return app, session, conn, remote, overflows
+}
+
+func readPayloads(t *testing.T, remote net.Conn, n int) []string {
+	t.Helper()
+	var payloads []string
+	head := make([]byte, DefaultHeader.Size())
+	for i := 0; i < n; i++ {
+		var h packetHead
+		if _, err := io.ReadFull(remote, head); err != nil {
+			t.Fatal(err)
+		}
+		DefaultHeader.decode(head, &h)
+		payload := make([]byte, h.Size)
+		if _, err := io.ReadFull(remote, payload); err != nil {
+			t.Fatal(err)
+		}
+		payloads = append(payloads, string(payload))
+	}
+	return payloads
+}
+
+func testPayloads(t *testing.T, remote net.Conn, expected ...string) {
+	t.Helper()
+	payloads := readPayloads(t, remote, len(expected))
+	for i := range expected {
+		if payloads[i] != expected[i] {
+			t.Fatalf("received %q, expected %q", payloads, expected)
+		}
+	}
+}
+
+func TestSendDisconnect(t *testing.T) {
+	app, session, conn, remote, overflows := newBlockedSession(t, SendDisconnect)
+	defer remote.Close()
+	defer close(conn.gate)
+
+	if err := session.Send(&testEcho{[]byte("4")}); err != link.SessionBlockedError {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !session.IsClosed() {
+		t.Fatal("session not closed")
+	}
+	if n := app.Stats().SendKicked; n != 1 {
+		t.Fatalf("SendKicked = %d", n)
+	}
+	if len(*overflows) != 1 || (*overflows)[0] != SendDisconnect {
+		t.Fatalf("OnSendOverflow calls: %v", *overflows)
+	}
+}
+
+func TestSendDropNewest(t *testing.T) {
+	app, session, conn, remote, overflows := newBlockedSession(t, SendDropNewest)
+	defer remote.Close()
+	defer session.Close()
+
+	if err := session.Send(&testEcho{[]byte("4")}); err != nil {
+		t.Fatal(err)
+	}
+	close(conn.gate)
+	testPayloads(t, remote, "1", "2", "3")
+	if n := app.Stats().SendDropped; n != 1 {
+		t.Fatalf("SendDropped = %d", n)
+	}
+	if len(*overflows) != 1 || (*overflows)[0] != SendDropNewest {
+		t.Fatalf("OnSendOverflow calls: %v", *overflows)
+	}
+}
+
+func TestSendDropOldest(t *testing.T) {
+	app, session, conn, remote, _ := newBlockedSession(t, SendDropOldest)
+	defer remote.Close()
+	defer session.Close()
+
+	if err := session.Send(&testEcho{[]byte("4")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The packets of higher priority are never dropped for lower one.
+	session.Send(&testHighEcho{testEcho{[]byte("5")}})
+	session.Send(&testHighEcho{testEcho{[]byte("6")}})
+	session.Send(&testEcho{[]byte("7")})
+
+	close(conn.gate)
+	testPayloads(t, remote, "1", "5", "6")
+	if n := app.Stats().SendDropped; n != 4 {
+		t.Fatalf("SendDropped = %d", n)
+	}
+}
+
+func TestSendBlock(t *testing.T) {
+	app, session, conn, remote, _ := newBlockedSession(t, SendBlock)
+	defer remote.Close()
+	defer session.Close()
+
+	sent := make(chan error)
+	go func() {
+		sent <- session.Send(&testEcho{[]byte("4")})
+	}()
+	select {
+	case err := <-sent:
+		t.Fatalf("send not blocked: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(conn.gate)
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	testPayloads(t, remote, "1", "2", "3", "4")
+	if n := app.Stats().SendBlocked; n != 1 {
+		t.Fatalf("SendBlocked = %d", n)
+	}
+}
+
+func TestSendBlockClosed(t *testing.T) {
+	_, session, conn, remote, _ := newBlockedSession(t, SendBlock)
+	defer remote.Close()
+	defer close(conn.gate)
+
+	sent := make(chan error)
+	go func() {
+		sent <- session.Send(&testEcho{[]byte("4")})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	session.Close()
+	if err := <-sent; err != link.SessionClosedError {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package fastapi
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// sendBatch collects queued packets after the first one and writes them by
+// one writev() call.
+func (c *codec) sendBatch(packet []byte) error {
+	batch := append(c.batch[:0], packet)
+	size := len(packet)
+
+	var timeout <-chan time.Time
+	if c.app.SendBatchDelay > 0 {
+		timer := time.NewTimer(c.app.SendBatchDelay)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+collect:
+	for size < c.app.SendBatchSize {
+		if packet := c.queue.Pop(); packet != nil {
+			batch = append(batch, packet)
+			size += len(packet)
+			continue
+		}
+		if timeout == nil {
+			break collect
+		}
+		select {
+		case <-c.queue.ready:
+		case <-timeout:
+			break collect
+		case <-c.closeChan:
+			break collect
+		}
+	}
+
+	atomic.AddUint64(&c.app.stats.SendBatches, 1)
+	atomic.AddUint64(&c.app.stats.SendBatchPackets, uint64(len(batch)))
+	atomic.AddUint64(&c.app.stats.SendBatchBytes, uint64(size))
+
+	// WriteTo() consumes the buffers, so write a copy and free the origin.
+	buffers := append(c.buffers[:0], batch...)
+	err := c.writeBuffers(buffers)
+	for i := range batch {
+		c.app.Pool.Free(batch[i])
+		batch[i] = nil
+		buffers[i] = nil
+	}
+	c.batch = batch[:0]
+	c.buffers = buffers[:0]
+	return err
+}
+
+func (c *codec) writeBuffers(buffers net.Buffers) (err error) {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	if c.app.SendTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.app.SendTimeout))
+		defer c.conn.SetWriteDeadline(time.Time{})
+	}
+
+	for _, packet := range buffers {
+		c.putSequence(packet)
+	}
+	_, err = buffers.WriteTo(c.conn)
+	return
+}
@@ -0,0 +1,91 @@
+package fastapi
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Compressed payload: serviceID(2) + messageID(2) + message size(4) + deflate
+// data of the message.
+const compressHeadSize = 2 + 2 + 4
+
+var flateWriters = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+var flateReaders = sync.Pool{
+	New: func() interface{} {
+		return flate.NewReader(nil)
+	},
+}
+
+func (c *codec) canCompress(packetSize int) bool {
+	return c.features&FeatureCompress != 0 &&
+		c.app.CompressThreshold > 0 &&
+		packetSize >= c.app.CompressThreshold
+}
+
+// compress returns the control packet carries the compressed message, it's
+// nil when the compressed one isn't smaller.
+func (c *codec) compress(msg Message, payload []byte, head packetHead) []byte {
+	var buf bytes.Buffer
+	w := flateWriters.Get().(*flate.Writer)
+	w.Reset(&buf)
+	w.Write(payload)
+	err := w.Close()
+	flateWriters.Put(w)
+
+	size := compressHeadSize + buf.Len()
+	if err != nil || size >= len(payload) || size > c.maxSendSize {
+		return nil
+	}
+
+	packet := c.app.Pool.Alloc(c.headSize + size)
+	head.Size, head.ServiceID, head.MessageID = size, controlServiceID, uint16(controlCompressed)
+	c.putHead(packet, head)
+	p := packet[c.headSize:]
+	binary.LittleEndian.PutUint16(p, msg.ServiceID())
+	binary.LittleEndian.PutUint16(p[2:], msg.MessageID())
+	binary.LittleEndian.PutUint32(p[4:], uint32(len(payload)))
+	copy(p[compressHeadSize:], buf.Bytes())
+	return packet
+}
+
+func (c *codec) recvCompressed(payload []byte) (Message, error) {
+	if c.features&FeatureCompress == 0 || len(payload) < compressHeadSize {
+		return nil, DecodeError{"Unexpected Compressed Packet"}
+	}
+
+	serviceID := binary.LittleEndian.Uint16(payload)
+	messageID := binary.LittleEndian.Uint16(payload[2:])
+	size := int(binary.LittleEndian.Uint32(payload[4:]))
+	if size > c.app.MaxRecvSize {
+		return nil, DecodeError{fmt.Sprintf("Too Large Compressed Message Size: %d", size)}
+	}
+
+	r := flateReaders.Get().(io.ReadCloser)
+	r.(flate.Resetter).Reset(bytes.NewReader(payload[compressHeadSize:]), nil)
+	buf := c.app.Pool.Alloc(size)
+	_, err := io.ReadFull(r, buf)
+	flateReaders.Put(r)
+	if err != nil {
+		c.app.Pool.Free(buf)
+		return nil, DecodeError{fmt.Sprintf("Bad Compressed Packet: [%d, %d] %s", serviceID, messageID, err)}
+	}
+
+	c.record(Inbound, serviceID, messageID, buf)
+
+	msg, err := c.decode(serviceID, messageID, buf)
+	if err == nil {
+		c.app.recordBytesIn(msg, size)
+	}
+	c.freePacket(msg, buf)
+	return msg, err
+}
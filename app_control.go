@@ -0,0 +1,54 @@
+package fastapi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Packets of controlServiceID are handled inside codec and never be routed to
+// services, when the session negotiated any of controlFeatures in handshake.
+// Sessions without handshake treat it as a normal service id, and the App has
+// service 255 registered doesn't offer controlFeatures.
+const controlServiceID = 255
+
+const controlFeatures = FeatureHeartbeat | FeatureFragment | FeatureCompress
+
+const (
+	controlPing byte = iota
+	controlPong
+	controlFragment
+	controlCompressed
+)
+
+func (c *codec) sendControl(id byte, payload []byte) error {
+	packet := c.app.Pool.Alloc(c.headSize + len(payload))
+	c.format.encode(packet, &packetHead{
+		Size:      len(payload),
+		ServiceID: controlServiceID,
+		MessageID: uint16(id),
+	})
+	copy(packet[c.headSize:], payload)
+	err := c.write(packet)
+	c.app.Pool.Free(packet)
+	return err
+}
+
+func (c *codec) handleControl(id byte, payload []byte) (Message, error) {
+	switch id {
+	case controlPing:
+		return nil, c.sendControl(controlPong, payload)
+	case controlPong:
+		if len(payload) == 8 {
+			sendTime := int64(binary.LittleEndian.Uint64(payload))
+			atomic.StoreInt64(&c.rtt, time.Now().UnixNano()-sendTime)
+		}
+		return nil, nil
+	case controlFragment:
+		return c.recvFragment(payload)
+	case controlCompressed:
+		return c.recvCompressed(payload)
+	}
+	return nil, DecodeError{fmt.Sprintf("Unsupported Control Packet: %d", id)}
+}
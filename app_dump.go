@@ -0,0 +1,166 @@
+package fastapi
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// The limits of DumpBuilder, longer values are truncated.
+var (
+	MaxDumpBytes  = 32
+	MaxDumpString = 64
+	MaxDumpItems  = 8
+	MaxDumpDepth  = 4
+)
+
+// Dumper is implemented by the generated messages, Dump() returns the field
+// names and values of the message for debugging.
+type Dumper interface {
+	Dump() string
+}
+
+// DumpMessage returns msg.Dump() when it's implemented, or the hex of the
+// packet otherwise.
+func DumpMessage(msg Message) string {
+	if d, ok := msg.(Dumper); ok {
+		return d.Dump()
+	}
+	return msg.Identity() + " " + dumpPayload(msg)
+}
+
+// DumpBuilder is used by the generated Dump() methods:
+//
+//	var d fastapi.DumpBuilder
+//	d.Begin(this.Identity())
+//	d.Field("A", this.A)
+//	return d.End()
+type DumpBuilder struct {
+	b      strings.Builder
+	fields int
+}
+
+func (d *DumpBuilder) Begin(name string) {
+	d.b.WriteString(name)
+	d.b.WriteByte('{')
+}
+
+func (d *DumpBuilder) Field(name string, value interface{}) {
+	if d.fields > 0 {
+		d.b.WriteString(", ")
+	}
+	d.fields++
+	d.b.WriteString(name)
+	d.b.WriteString(": ")
+	dumpValue(&d.b, reflect.ValueOf(value), 0)
+}
+
+func (d *DumpBuilder) End() string {
+	d.b.WriteByte('}')
+	return d.b.String()
+}
+
+func dumpValue(b *strings.Builder, v reflect.Value, depth int) {
+	if !v.IsValid() {
+		b.WriteString("nil")
+		return
+	}
+	if depth > MaxDumpDepth {
+		b.WriteString("...")
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		s := v.String()
+		if len(s) > MaxDumpString {
+			b.WriteString(strconv.Quote(s[:MaxDumpString]))
+			fmt.Fprintf(b, "...(%d bytes)", len(s))
+			return
+		}
+		b.WriteString(strconv.Quote(s))
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			b.WriteString("nil")
+			return
+		}
+		dumpValue(b, v.Elem(), depth+1)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			b.WriteString("nil")
+			return
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			dumpBytes(b, v)
+			return
+		}
+		b.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if i == MaxDumpItems {
+				fmt.Fprintf(b, "...(%d items)", v.Len())
+				break
+			}
+			dumpValue(b, v.Index(i), depth+1)
+		}
+		b.WriteByte(']')
+	case reflect.Map:
+		if v.IsNil() {
+			b.WriteString("nil")
+			return
+		}
+		b.WriteString("map[")
+		for i, key := range v.MapKeys() {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if i == MaxDumpItems {
+				fmt.Fprintf(b, "...(%d items)", v.Len())
+				break
+			}
+			dumpValue(b, key, depth+1)
+			b.WriteString(": ")
+			dumpValue(b, v.MapIndex(key), depth+1)
+		}
+		b.WriteByte(']')
+	case reflect.Struct:
+		b.WriteByte('{')
+		n := 0
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			if n > 0 {
+				b.WriteString(", ")
+			}
+			n++
+			b.WriteString(field.Name)
+			b.WriteString(": ")
+			dumpValue(b, v.Field(i), depth+1)
+		}
+		b.WriteByte('}')
+	default:
+		fmt.Fprint(b, v.Interface())
+	}
+}
+
+func dumpBytes(b *strings.Builder, v reflect.Value) {
+	n := v.Len()
+	if n > MaxDumpBytes {
+		n = MaxDumpBytes
+	}
+	buf := make([]byte, n)
+	for i := range buf {
+		buf[i] = byte(v.Index(i).Uint())
+	}
+	b.WriteString("0x")
+	b.WriteString(hex.EncodeToString(buf))
+	if v.Len() > n {
+		fmt.Fprintf(b, "...(%d bytes)", v.Len())
+	}
+}
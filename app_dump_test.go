@@ -0,0 +1,71 @@
+package fastapi
+
+import (
+	"strings"
+	"testing"
+)
+
+type testDumpItem struct {
+	ID    int
+	Name  string
+	inner int
+}
+
+type testDumpEcho struct {
+	testEcho
+}
+
+func (m *testDumpEcho) Dump() string {
+	var d DumpBuilder
+	d.Begin(m.Identity())
+	d.Field("Data", m.Data)
+	return d.End()
+}
+
+func TestDumpMessage(t *testing.T) {
+	if s := DumpMessage(&testEcho{[]byte("hello")}); s != "testService.testEcho 68656c6c6f" {
+		t.Fatalf("unexpected dump: %s", s)
+	}
+	if s := DumpMessage(&testDumpEcho{testEcho{[]byte("hello")}}); s != "testService.testEcho{Data: 0x68656c6c6f}" {
+		t.Fatalf("unexpected dump: %s", s)
+	}
+}
+
+func TestDumpBuilder(t *testing.T) {
+	var nilItem *testDumpItem
+	for _, c := range []struct {
+		value    interface{}
+		expected string
+	}{
+		{1, "1"},
+		{true, "true"},
+		{"a\"b", `"a\"b"`},
+		{strings.Repeat("a", MaxDumpString+1), `"` + strings.Repeat("a", MaxDumpString) + `"...(65 bytes)`},
+		{[]byte{1, 2}, "0x0102"},
+		{make([]byte, MaxDumpBytes+1), "0x" + strings.Repeat("00", MaxDumpBytes) + "...(33 bytes)"},
+		{[]byte(nil), "nil"},
+		{[]int{1, 2, 3}, "[1, 2, 3]"},
+		{make([]int, MaxDumpItems+1), "[0, 0, 0, 0, 0, 0, 0, 0, ...(9 items)]"},
+		{map[string]int{"a": 1}, `map["a": 1]`},
+		{testDumpItem{1, "x", 2}, `{ID: 1, Name: "x"}`},
+		{&testDumpItem{1, "x", 2}, `{ID: 1, Name: "x"}`},
+		{nilItem, "nil"},
+		{nil, "nil"},
+		{[][][][][]int{{{{{1}}}}}, "[[[[[...]]]]]"},
+	} {
+		var d DumpBuilder
+		d.Begin("M")
+		d.Field("A", c.value)
+		if s, expected := d.End(), "M{A: "+c.expected+"}"; s != expected {
+			t.Fatalf("dump %#v: %s, expected %s", c.value, s, expected)
+		}
+	}
+
+	var d DumpBuilder
+	d.Begin("M")
+	d.Field("A", 1)
+	d.Field("B", "b")
+	if s := d.End(); s != `M{A: 1, B: "b"}` {
+		t.Fatalf("unexpected dump: %s", s)
+	}
+}
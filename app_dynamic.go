@@ -0,0 +1,399 @@
+package fastapi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Encode marshals the value decoded from JSON into fastbin format, numbers
+// are expected as json.Number (json.Decoder.UseNumber) or float64. Missing
+// struct fields and null are encoded as zero value, []byte is base64 string.
+//
+// The fastbin layout: integers and floats are fixed size little endian, int
+// and uint are 8 bytes, bool is 1 byte, string, []byte, slice and map are
+// prefixed by 2 bytes little endian length, so they have 65535 items at most,
+// array has no length, pointer is prefixed by 1 byte nil flag, struct is the
+// fields in order.
+func (t *SchemaType) Encode(v interface{}) ([]byte, error) {
+	return encodeDynamic(nil, t, v)
+}
+
+// Decode unmarshals fastbin data into the value can be marshaled to JSON,
+// struct fields are kept in order.
+func (t *SchemaType) Decode(data []byte) (interface{}, error) {
+	d := &dynamicDecoder{data: data}
+	v := d.decode(t)
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.data) > 0 {
+		return nil, SchemaError{fmt.Sprintf("%d Bytes Left", len(d.data))}
+	}
+	return v, nil
+}
+
+func intSize(kind string) int {
+	switch kind {
+	case "int8", "uint8", "bool":
+		return 1
+	case "int16", "uint16":
+		return 2
+	case "int32", "uint32", "float32":
+		return 4
+	}
+	return 8
+}
+
+func putUintLE(buf []byte, size int, v uint64) []byte {
+	for i := 0; i < size; i++ {
+		buf = append(buf, byte(v>>(8*uint(i))))
+	}
+	return buf
+}
+
+func putLength(buf []byte, n int) ([]byte, error) {
+	if n > math.MaxUint16 {
+		return nil, SchemaError{fmt.Sprintf("Too Long: %d > %d", n, math.MaxUint16)}
+	}
+	return putUintLE(buf, 2, uint64(n)), nil
+}
+
+func toNumber(v interface{}) (json.Number, error) {
+	switch n := v.(type) {
+	case nil:
+		return "0", nil
+	case json.Number:
+		return n, nil
+	case float64:
+		return json.Number(strconv.FormatFloat(n, 'f', -1, 64)), nil
+	case string:
+		return json.Number(n), nil
+	}
+	return "", SchemaError{fmt.Sprintf("Not A Number: %v", v)}
+}
+
+func toBytes(v interface{}) ([]byte, error) {
+	switch b := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return base64.StdEncoding.DecodeString(b)
+	case []interface{}:
+		buf := make([]byte, len(b))
+		for i, item := range b {
+			n, err := toNumber(item)
+			if err != nil {
+				return nil, err
+			}
+			u, err := strconv.ParseUint(n.String(), 10, 8)
+			if err != nil {
+				return nil, err
+			}
+			buf[i] = byte(u)
+		}
+		return buf, nil
+	}
+	return nil, SchemaError{fmt.Sprintf("Not Bytes: %v", v)}
+}
+
+func encodeDynamic(buf []byte, t *SchemaType, v interface{}) ([]byte, error) {
+	switch t.Kind {
+	case "bool":
+		b, ok := v.(bool)
+		if !ok && v != nil {
+			return nil, SchemaError{fmt.Sprintf("Not A Bool: %v", v)}
+		}
+		if b {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+
+	case "int", "int8", "int16", "int32", "int64":
+		n, err := toNumber(v)
+		if err != nil {
+			return nil, err
+		}
+		size := intSize(t.Kind)
+		i, err := strconv.ParseInt(n.String(), 10, 8*size)
+		if err != nil {
+			return nil, SchemaError{err}
+		}
+		return putUintLE(buf, size, uint64(i)), nil
+
+	case "uint", "uint8", "uint16", "uint32", "uint64":
+		n, err := toNumber(v)
+		if err != nil {
+			return nil, err
+		}
+		size := intSize(t.Kind)
+		u, err := strconv.ParseUint(n.String(), 10, 8*size)
+		if err != nil {
+			return nil, SchemaError{err}
+		}
+		return putUintLE(buf, size, u), nil
+
+	case "float32", "float64":
+		n, err := toNumber(v)
+		if err != nil {
+			return nil, err
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return nil, SchemaError{err}
+		}
+		if t.Kind == "float32" {
+			return putUintLE(buf, 4, uint64(math.Float32bits(float32(f)))), nil
+		}
+		return putUintLE(buf, 8, math.Float64bits(f)), nil
+
+	case "string":
+		s, ok := v.(string)
+		if !ok && v != nil {
+			return nil, SchemaError{fmt.Sprintf("Not A String: %v", v)}
+		}
+		buf, err := putLength(buf, len(s))
+		if err != nil {
+			return nil, err
+		}
+		return append(buf, s...), nil
+
+	case "ptr":
+		if v == nil {
+			return append(buf, 0), nil
+		}
+		return encodeDynamic(append(buf, 1), t.Elem, v)
+
+	case "slice":
+		if t.Elem.Kind == "uint8" {
+			b, err := toBytes(v)
+			if err != nil {
+				return nil, err
+			}
+			if buf, err = putLength(buf, len(b)); err != nil {
+				return nil, err
+			}
+			return append(buf, b...), nil
+		}
+		items, ok := v.([]interface{})
+		if !ok && v != nil {
+			return nil, SchemaError{fmt.Sprintf("Not An Array: %v", v)}
+		}
+		buf, err := putLength(buf, len(items))
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if buf, err = encodeDynamic(buf, t.Elem, item); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+
+	case "array":
+		var items []interface{}
+		if t.Elem.Kind == "uint8" {
+			b, err := toBytes(v)
+			if err != nil {
+				return nil, err
+			}
+			for _, c := range b {
+				items = append(items, json.Number(strconv.Itoa(int(c))))
+			}
+		} else if v != nil {
+			var ok bool
+			if items, ok = v.([]interface{}); !ok {
+				return nil, SchemaError{fmt.Sprintf("Not An Array: %v", v)}
+			}
+		}
+		if len(items) > t.Len {
+			return nil, SchemaError{fmt.Sprintf("Too Many Items: %d > %d", len(items), t.Len)}
+		}
+		for i := 0; i < t.Len; i++ {
+			var item interface{}
+			if i < len(items) {
+				item = items[i]
+			}
+			var err error
+			if buf, err = encodeDynamic(buf, t.Elem, item); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+
+	case "map":
+		m, ok := v.(map[string]interface{})
+		if !ok && v != nil {
+			return nil, SchemaError{fmt.Sprintf("Not An Object: %v", v)}
+		}
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf, err := putLength(buf, len(keys))
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			var k interface{} = key
+			if t.Key.Kind != "string" {
+				k = json.Number(key)
+			}
+			if buf, err = encodeDynamic(buf, t.Key, k); err != nil {
+				return nil, err
+			}
+			if buf, err = encodeDynamic(buf, t.Elem, m[key]); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+
+	case "struct":
+		m, ok := v.(map[string]interface{})
+		if !ok && v != nil {
+			return nil, SchemaError{fmt.Sprintf("Not An Object: %v", v)}
+		}
+		for key := range m {
+			if t.field(key) == nil {
+				return nil, SchemaError{fmt.Sprintf("Unknown Field: %s.%s", t.Name, key)}
+			}
+		}
+		for _, field := range t.Fields {
+			var err error
+			if buf, err = encodeDynamic(buf, field.Type, m[field.Name]); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	return nil, SchemaError{fmt.Sprintf("Unsupported Kind: %s", t.Kind)}
+}
+
+func (t *SchemaType) field(name string) *SchemaField {
+	for i := range t.Fields {
+		if t.Fields[i].Name == name {
+			return &t.Fields[i]
+		}
+	}
+	return nil
+}
+
+type dynamicDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *dynamicDecoder) read(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data) {
+		d.err = SchemaError{"Unexpected End Of Data"}
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *dynamicDecoder) uint(size int) uint64 {
+	b := d.read(size)
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v
+}
+
+func (d *dynamicDecoder) length() int {
+	return int(d.uint(2))
+}
+
+func (d *dynamicDecoder) decode(t *SchemaType) interface{} {
+	if d.err != nil {
+		return nil
+	}
+	switch t.Kind {
+	case "bool":
+		return d.uint(1) != 0
+	case "int", "int8", "int16", "int32", "int64":
+		size := intSize(t.Kind)
+		shift := uint(64 - 8*size)
+		return int64(d.uint(size)<<shift) >> shift
+	case "uint", "uint8", "uint16", "uint32", "uint64":
+		return d.uint(intSize(t.Kind))
+	case "float32":
+		return math.Float32frombits(uint32(d.uint(4)))
+	case "float64":
+		return math.Float64frombits(d.uint(8))
+	case "string":
+		return string(d.read(d.length()))
+	case "ptr":
+		if d.uint(1) == 0 {
+			return nil
+		}
+		return d.decode(t.Elem)
+	case "slice", "array":
+		n := t.Len
+		if t.Kind == "slice" {
+			n = d.length()
+		}
+		if t.Elem.Kind == "uint8" {
+			return append([]byte(nil), d.read(n)...)
+		}
+		items := []interface{}{}
+		for i := 0; i < n && d.err == nil; i++ {
+			items = append(items, d.decode(t.Elem))
+		}
+		return items
+	case "map":
+		n := d.length()
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			key := fmt.Sprint(d.decode(t.Key))
+			m[key] = d.decode(t.Elem)
+		}
+		return m
+	case "struct":
+		obj := make(orderedObject, 0, len(t.Fields))
+		for _, field := range t.Fields {
+			obj = append(obj, objectField{field.Name, d.decode(field.Type)})
+		}
+		return obj
+	}
+	d.err = SchemaError{fmt.Sprintf("Unsupported Kind: %s", t.Kind)}
+	return nil
+}
+
+type objectField struct {
+	Name  string
+	Value interface{}
+}
+
+// orderedObject marshals struct fields in declaration order.
+type orderedObject []objectField
+
+func (obj orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range obj {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(field.Name)
+		buf.Write(name)
+		buf.WriteByte(':')
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,204 @@
+package fastapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/funny/binary"
+)
+
+type dynamicItem struct {
+	ID   uint32
+	Name string
+}
+
+type dynamicMessage struct {
+	Name  string
+	Data  []byte
+	Items []dynamicItem
+	Tags  map[string]int16
+	Ref   *dynamicItem
+	Score *float64
+	Pos   [3]float32
+	OK    bool
+}
+
+// The methods below are written in the layout generated by fastbin.
+
+func (this *dynamicItem) BinarySize() (n int) {
+	n = 4 + 2 + len(this.Name)
+	return n
+}
+
+func (this *dynamicItem) MarshalWriter(w binary.BinaryWriter) {
+	w.WriteUint32LE(this.ID)
+	w.WriteUint16LE(uint16(len(this.Name)))
+	w.WriteString(this.Name)
+}
+
+func (this *dynamicItem) UnmarshalReader(r binary.BinaryReader) {
+	this.ID = r.ReadUint32LE()
+	this.Name = r.ReadString(int(r.ReadUint16LE()))
+}
+
+func (this *dynamicMessage) BinarySize() (n int) {
+	n = 2 + len(this.Name) + 2 + len(this.Data) + 2
+	for i := range this.Items {
+		n += this.Items[i].BinarySize()
+	}
+	n += 2
+	for key := range this.Tags {
+		n += 2 + len(key) + 2
+	}
+	n += 1
+	if this.Ref != nil {
+		n += this.Ref.BinarySize()
+	}
+	n += 1
+	if this.Score != nil {
+		n += 8
+	}
+	n += 3*4 + 1
+	return n
+}
+
+func (this *dynamicMessage) MarshalPacket(p []byte) {
+	var buf = binary.Buffer{Data: p}
+	this.MarshalWriter(&buf)
+}
+
+func (this *dynamicMessage) UnmarshalPacket(p []byte) {
+	var buf = binary.Buffer{Data: p}
+	this.UnmarshalReader(&buf)
+}
+
+func (this *dynamicMessage) MarshalWriter(w binary.BinaryWriter) {
+	w.WriteUint16LE(uint16(len(this.Name)))
+	w.WriteString(this.Name)
+	w.WriteUint16LE(uint16(len(this.Data)))
+	w.WriteBytes(this.Data)
+	w.WriteUint16LE(uint16(len(this.Items)))
+	for i := range this.Items {
+		this.Items[i].MarshalWriter(w)
+	}
+	w.WriteUint16LE(uint16(len(this.Tags)))
+	for key, value := range this.Tags {
+		w.WriteUint16LE(uint16(len(key)))
+		w.WriteString(key)
+		w.WriteUint16LE(uint16(value))
+	}
+	if this.Ref == nil {
+		w.WriteUint8(0)
+	} else {
+		w.WriteUint8(1)
+		this.Ref.MarshalWriter(w)
+	}
+	if this.Score == nil {
+		w.WriteUint8(0)
+	} else {
+		w.WriteUint8(1)
+		w.WriteFloat64LE(*this.Score)
+	}
+	for i := range this.Pos {
+		w.WriteFloat32LE(this.Pos[i])
+	}
+	if this.OK {
+		w.WriteUint8(1)
+	} else {
+		w.WriteUint8(0)
+	}
+}
+
+func (this *dynamicMessage) UnmarshalReader(r binary.BinaryReader) {
+	this.Name = r.ReadString(int(r.ReadUint16LE()))
+	this.Data = r.ReadBytes(int(r.ReadUint16LE()))
+	this.Items = make([]dynamicItem, r.ReadUint16LE())
+	for i := range this.Items {
+		this.Items[i].UnmarshalReader(r)
+	}
+	n := int(r.ReadUint16LE())
+	this.Tags = make(map[string]int16, n)
+	for i := 0; i < n; i++ {
+		key := r.ReadString(int(r.ReadUint16LE()))
+		this.Tags[key] = int16(r.ReadUint16LE())
+	}
+	if r.ReadUint8() == 1 {
+		this.Ref = new(dynamicItem)
+		this.Ref.UnmarshalReader(r)
+	}
+	if r.ReadUint8() == 1 {
+		this.Score = new(float64)
+		*this.Score = r.ReadFloat64LE()
+	}
+	for i := range this.Pos {
+		this.Pos[i] = r.ReadFloat32LE()
+	}
+	this.OK = r.ReadUint8() == 1
+}
+
+func TestDynamicCodec(t *testing.T) {
+	st, err := schemaType(reflect.TypeOf(dynamicMessage{}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &dynamicMessage{
+		Name:  "fastapi",
+		Data:  []byte{1, 2, 3},
+		Items: []dynamicItem{{1, "a"}, {2, "b"}},
+		Tags:  map[string]int16{"x": -1},
+		Ref:   &dynamicItem{3, "c"},
+		Pos:   [3]float32{1.5, -2, 0},
+		OK:    true,
+	}
+	text := `{"Name":"fastapi","Data":"AQID","Items":[{"ID":1,"Name":"a"},{"ID":2,"Name":"b"}],"Tags":{"x":-1},"Ref":{"ID":3,"Name":"c"},"Score":null,"Pos":[1.5,-2,0],"OK":true}`
+
+	packet := make([]byte, msg.BinarySize())
+	msg.MarshalPacket(packet)
+
+	// JSON -> SchemaType.Encode -> UnmarshalPacket
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	data, err := st.Encode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, packet) {
+		t.Fatalf("encoded % x, expected % x", data, packet)
+	}
+	var msg2 dynamicMessage
+	msg2.UnmarshalPacket(data)
+	if !reflect.DeepEqual(&msg2, msg) {
+		t.Fatalf("unmarshaled %+v, expected %+v", msg2, msg)
+	}
+
+	// MarshalPacket -> SchemaType.Decode -> JSON
+	v, err = st.Decode(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != text {
+		t.Fatalf("decoded %s, expected %s", result, text)
+	}
+}
+
+func TestDynamicCodecTooLong(t *testing.T) {
+	st := &SchemaType{Kind: "string"}
+	if _, err := st.Encode(strings.Repeat("x", 65536)); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := st.Encode(strings.Repeat("x", 65535)); err != nil {
+		t.Fatal(err)
+	}
+}
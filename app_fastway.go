@@ -0,0 +1,81 @@
+package fastapi
+
+import (
+	"sync"
+
+	"github.com/funny/link"
+)
+
+// fastwaySenders maps the fastway sessions to their senders, Send() looks up
+// the sender since the codec of fastway session is not *codec.
+var fastwaySenders sync.Map
+
+// encodedFrame is the message encoded by fastwaySender, msgFormat passes it
+// to fastway as is.
+type encodedFrame []byte
+
+// fastwaySender is the send queue of fastway virtual session, the messages
+// sent by Send() are encoded in caller's goroutine and queued by priority,
+// the frames are handed to fastway by the goroutine of sender. The frames of
+// all virtual sessions are still queued by the fastway connection in FIFO
+// order, the priority only reorders the frames waiting in the sender.
+type fastwaySender struct {
+	app       *App
+	session   *link.Session
+	format    *msgFormat
+	queue     *sendQueue
+	closeChan chan struct{}
+	closeOnce sync.Once
+}
+
+func (app *App) newFastwaySender(session *link.Session) *fastwaySender {
+	s := &fastwaySender{
+		app:       app,
+		session:   session,
+		format:    &msgFormat{app, app.newRequest},
+		queue:     newSendQueue(app.SendChanSize),
+		closeChan: make(chan struct{}),
+	}
+	fastwaySenders.Store(session, s)
+	go s.sendLoop()
+	return s
+}
+
+func (s *fastwaySender) Send(msg Message) error {
+	frame, err := s.format.EncodeMessage(msg)
+	if err != nil {
+		return err
+	}
+	m, _ := Untraced(msg)
+	level := priorityLevel(m)
+	if s.queue.Push(level, frame) {
+		return nil
+	}
+	return s.app.overflow(s.session, s.queue, s.closeChan, level, frame, func([]byte) {}, s.Close)
+}
+
+func (s *fastwaySender) sendLoop() {
+	for {
+		select {
+		case <-s.queue.ready:
+		case <-s.closeChan:
+			return
+		}
+		for frame := s.queue.Pop(); frame != nil; frame = s.queue.Pop() {
+			if s.session.Send(encodedFrame(frame)) != nil {
+				s.Close()
+				return
+			}
+		}
+	}
+}
+
+// Close stops the sender and closes the session, the queued frames are
+// dropped.
+func (s *fastwaySender) Close() {
+	s.closeOnce.Do(func() {
+		fastwaySenders.Delete(s.session)
+		close(s.closeChan)
+		s.session.Close()
+	})
+}
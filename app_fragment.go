@@ -0,0 +1,111 @@
+package fastapi
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Fragment payload: serviceID(2) + messageID(2) + message size(4) + chunk
+const fragmentHeadSize = 2 + 2 + 4
+
+type fragments struct {
+	serviceID uint16
+	messageID uint16
+	buf       []byte
+	n         int
+}
+
+func (c *codec) canFragment(packetSize int) bool {
+	return c.features&FeatureFragment != 0 &&
+		packetSize <= c.app.MaxMessageSize &&
+		c.maxSendSize > fragmentHeadSize
+}
+
+func (c *codec) maxMessageSize() int {
+	if c.features&FeatureFragment != 0 && c.app.MaxMessageSize > c.maxSendSize {
+		return c.app.MaxMessageSize
+	}
+	return c.maxSendSize
+}
+
+// encodeFragments puts all the fragment packets in one buffer, so they're
+// written by one conn.Write() and never interleaved with other packets.
+// The payload is the marshaled JSON message, it's nil for fastbin message.
+func (c *codec) encodeFragments(msg Message, packetSize int, payload []byte, head packetHead) ([]byte, error) {
+	buf := payload
+	if buf == nil {
+		buf = c.app.Pool.Alloc(packetSize)
+		defer c.app.Pool.Free(buf)
+
+		if err := marshal(msg, buf); err != nil {
+			return nil, err
+		}
+	}
+	c.record(Outbound, msg.ServiceID(), msg.MessageID(), buf)
+
+	chunkSize := c.maxSendSize - fragmentHeadSize
+	num := (packetSize + chunkSize - 1) / chunkSize
+	packet := c.app.Pool.Alloc(packetSize + num*(c.headSize+fragmentHeadSize))
+	head.ServiceID, head.MessageID = controlServiceID, uint16(controlFragment)
+
+	for p := packet; len(buf) > 0; {
+		chunk := buf
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		buf = buf[len(chunk):]
+
+		head.Size = fragmentHeadSize + len(chunk)
+		c.putHead(p, head)
+		f := p[c.headSize:]
+		binary.LittleEndian.PutUint16(f, msg.ServiceID())
+		binary.LittleEndian.PutUint16(f[2:], msg.MessageID())
+		binary.LittleEndian.PutUint32(f[4:], uint32(packetSize))
+		n := copy(f[fragmentHeadSize:], chunk)
+		p = f[fragmentHeadSize+n:]
+	}
+	return packet, nil
+}
+
+func (c *codec) recvFragment(payload []byte) (Message, error) {
+	if c.features&FeatureFragment == 0 || len(payload) < fragmentHeadSize {
+		return nil, DecodeError{"Unexpected Fragment"}
+	}
+
+	f := &c.fragments
+	serviceID := binary.LittleEndian.Uint16(payload)
+	messageID := binary.LittleEndian.Uint16(payload[2:])
+	size := int(binary.LittleEndian.Uint32(payload[4:]))
+	chunk := payload[fragmentHeadSize:]
+
+	if f.buf == nil {
+		if size > c.app.MaxMessageSize {
+			return nil, DecodeError{fmt.Sprintf("Too Large Fragmented Message Size: %d", size)}
+		}
+		f.serviceID = serviceID
+		f.messageID = messageID
+		f.buf = c.app.Pool.Alloc(size)
+		f.n = 0
+	} else if serviceID != f.serviceID || messageID != f.messageID || size != len(f.buf) {
+		return nil, DecodeError{fmt.Sprintf("Interleaved Fragment: [%d, %d]", serviceID, messageID)}
+	}
+
+	if f.n+len(chunk) > len(f.buf) {
+		return nil, DecodeError{fmt.Sprintf("Fragment Overflow: [%d, %d]", serviceID, messageID)}
+	}
+	f.n += copy(f.buf[f.n:], chunk)
+
+	if f.n < len(f.buf) {
+		return nil, nil
+	}
+
+	c.record(Inbound, f.serviceID, f.messageID, f.buf)
+
+	msg, err := c.decode(f.serviceID, f.messageID, f.buf)
+	if err == nil {
+		c.app.recordBytesIn(msg, size)
+	}
+	c.freePacket(msg, f.buf)
+	f.buf = nil
+	return msg, err
+}
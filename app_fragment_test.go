@@ -0,0 +1,133 @@
+package fastapi
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+)
+
+type testBadMessage struct {
+	testEcho
+	size int
+}
+
+func (m *testBadMessage) BinarySize() int        { return m.size }
+func (m *testBadMessage) MarshalPacket(p []byte) { panic("bad message") }
+
+func newFragmentApp() *App {
+	app := newTestApp()
+	app.Handshake = true
+	app.MaxSendSize = 100
+	app.MaxRecvSize = 100
+	app.MaxMessageSize = 10000
+	return app
+}
+
+func TestFragment(t *testing.T) {
+	app := newFragmentApp()
+	session, err := app.Pipe(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	big := bytes.Repeat([]byte("0123456789"), 1000)
+	for _, size := range []int{0, 1, 99, 100, 101, 192, 193, 5000, 10000} {
+		testRoundTrip(t, session, big[:size])
+	}
+	testRoundTrip(t, session, big)
+	testRoundTrip(t, session, []byte("small after big"))
+}
+
+func TestFragmentSizeError(t *testing.T) {
+	app := newFragmentApp()
+	session, err := app.Pipe(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	err = session.Send(&testEcho{make([]byte, 10001)})
+	if sizeErr, ok := err.(SizeError); !ok || sizeErr.Size != 10001 || sizeErr.MaxSize != 10000 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := app.Stats().SendSizeErrors; n != 1 {
+		t.Fatalf("SendSizeErrors = %d", n)
+	}
+}
+
+func TestFragmentMarshalError(t *testing.T) {
+	app := newFragmentApp()
+	app.EnableMetrics = true
+	session, err := app.Pipe(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	msg := &testBadMessage{size: 1000}
+	if _, ok := session.Send(msg).(MarshalError); !ok {
+		t.Fatal("expected MarshalError")
+	}
+	if n := app.Stats().MarshalErrors; n != 1 {
+		t.Fatalf("MarshalErrors = %d", n)
+	}
+	m := app.messageMetrics(msg.ServiceID(), msg.Identity())
+	if n := atomic.LoadUint64(&m.errors); n != 1 {
+		t.Fatalf("message errors = %d", n)
+	}
+	if n := atomic.LoadUint64(&m.bytesOut); n != 0 {
+		t.Fatalf("bytes out of failed message = %d", n)
+	}
+}
+
+func TestFragmentNotNegotiated(t *testing.T) {
+	app := newFragmentApp()
+	app.Handshake = false
+	session, err := app.Pipe(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	testRoundTrip(t, session, make([]byte, 100))
+	err = session.Send(&testEcho{make([]byte, 101)})
+	if sizeErr, ok := err.(SizeError); !ok || sizeErr.MaxSize != 100 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestFragmentMixedApps(t *testing.T) {
+	for _, c := range []struct {
+		name       string
+		server     *App
+		negotiated bool
+	}{
+		{"default server", newTestApp(), false},
+		{"fragment server", newFragmentApp(), true},
+	} {
+		c.server.Handshake = true
+		listener := ListenPipe()
+		go c.server.NewServer(listener, nil).Serve()
+
+		client := newFragmentApp()
+		client.MaxSendSize = 50
+		session, err := client.DialPipe(listener)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := NegotiatedFeatures(session)&FeatureFragment != 0; got != c.negotiated {
+			t.Fatalf("%s: fragment negotiated %v", c.name, got)
+		}
+
+		testRoundTrip(t, session, make([]byte, 50))
+		if c.negotiated {
+			testRoundTrip(t, session, make([]byte, 5000))
+		} else if _, ok := Send(session, &testEcho{make([]byte, 51)}).(SizeError); !ok {
+			t.Fatalf("%s: expected SizeError", c.name)
+		}
+		testRoundTrip(t, session, []byte("still alive"))
+		session.Close()
+		listener.Close()
+	}
+}
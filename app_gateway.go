@@ -0,0 +1,289 @@
+package fastapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/funny/link"
+)
+
+// Gateway serves the registered services over HTTP:
+//
+//	POST /{service}/{message}
+//
+// The service and the message are the names or the ids, e.g. /Service/AddReq
+// or /1/1. The request body is the JSON of request message, it's dispatched
+// like the requests received by server sessions: each HTTP request has a
+// session initialized by Handler.InitSession(), which isn't counted or listed
+// as an active session of the App. The request is rate limited
+// and handled in Handler.Transaction(). The rate limits are per client, the
+// client is the authenticated identity or the remote IP, and the clients idle
+// for a minute are forgotten.
+// The response is the JSON of the message sent by handler, or a JSON array
+// when the handler sent more than one message, or 204 when nothing sent.
+type Gateway struct {
+	app       *App
+	handler   Handler
+	mutex     sync.Mutex
+	limiters  map[string]*gatewayLimiter
+	lastSweep time.Time
+
+	// Authenticate returns the identity of HTTP request, which is used by
+	// the access rules. The request is anonymous when it's nil.
+	Authenticate func(*http.Request) (*Identity, error)
+
+	// MaxBodySize limits the size of request body, default is MaxRecvSize.
+	MaxBodySize int64
+}
+
+const gatewayIdleTimeout = time.Minute
+
+type gatewayLimiter struct {
+	limiter *rateLimiter
+	last    time.Time
+}
+
+func (app *App) NewGateway(handler Handler) *Gateway {
+	if handler == nil {
+		handler = &noHandler{}
+	}
+	return &Gateway{
+		app:      app,
+		handler:  handler,
+		limiters: make(map[string]*gatewayLimiter),
+	}
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 2 {
+		http.Error(w, "path should be /{service}/{message}", http.StatusNotFound)
+		return
+	}
+	msgType := g.app.findRequest(parts[0], parts[1])
+	if msgType == nil {
+		http.Error(w, "unknown message", http.StatusNotFound)
+		return
+	}
+	req, err := g.app.newRequest(msgType.service.id, msgType.id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	maxBodySize := g.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = int64(g.app.MaxRecvSize)
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, req); err != nil {
+			http.Error(w, fmt.Sprintf("bad json: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	c := &gatewayCodec{remote: r.RemoteAddr}
+	session := link.NewSession(c, 0)
+	defer session.Close()
+	g.app.addRequest(session)
+	defer g.app.delRequest(session)
+
+	client := remoteHost(r.RemoteAddr)
+	if g.Authenticate != nil {
+		identity, err := g.Authenticate(r)
+		if err != nil {
+			atomic.AddUint64(&g.app.stats.AuthFailures, 1)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		g.app.SetIdentity(session, identity)
+		if identity != nil {
+			client = "identity:" + identity.Name
+		}
+	}
+
+	if g.app.SessionFactory != nil {
+		session.State = g.app.SessionFactory(session)
+	}
+
+	if err := g.handler.InitSession(session); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if limit := g.checkRateLimit(client, req); limit != nil {
+		g.app.rateLimited(session, req, limit)
+		g.writeResponses(w, c.responses(), http.StatusTooManyRequests)
+		return
+	}
+
+	var (
+		denied error
+		failed = true
+		done   = make(chan struct{})
+	)
+	g.handler.Transaction(session, req, func() {
+		defer close(done)
+		if err := g.app.authorize(session, req); err != nil {
+			g.app.recordError(req)
+			g.app.logRequest(session, req, 0, "denied")
+			denied, failed = err, false
+			return
+		}
+		g.app.handleRequest(session, req, SpanContext{})
+		failed = false
+	})
+	select {
+	case <-done:
+	case <-r.Context().Done():
+		return
+	}
+
+	if denied != nil {
+		http.Error(w, denied.Error(), http.StatusForbidden)
+		return
+	}
+	if failed {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	g.writeResponses(w, c.responses(), http.StatusOK)
+}
+
+func (g *Gateway) writeResponses(w http.ResponseWriter, responses []Message, code int) {
+	var (
+		data []byte
+		err  error
+	)
+	switch len(responses) {
+	case 0:
+		if code == http.StatusOK {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			http.Error(w, http.StatusText(code), code)
+		}
+		return
+	case 1:
+		data, err = json.Marshal(responses[0])
+	default:
+		data, err = json.Marshal(responses)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+// checkRateLimit checks the limits of App with the buckets of the client.
+func (g *Gateway) checkRateLimit(client string, req Message) *RateLimit {
+	if g.app.SessionRateLimit == nil && len(g.app.rateLimits) == 0 {
+		return nil
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(g.lastSweep) > gatewayIdleTimeout {
+		for key, l := range g.limiters {
+			if now.Sub(l.last) > gatewayIdleTimeout {
+				delete(g.limiters, key)
+			}
+		}
+		g.lastSweep = now
+	}
+
+	l, exists := g.limiters[client]
+	if !exists {
+		l = &gatewayLimiter{limiter: g.app.newRateLimiter()}
+		g.limiters[client] = l
+	}
+	l.last = now
+	return l.limiter.Check(req)
+}
+
+func remoteHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+func (app *App) findRequest(service, message string) *MessageType {
+	for _, serviceType := range app.serviceTypes {
+		if !matchName(service, serviceType.Name(), serviceType.id) {
+			continue
+		}
+		for _, req := range serviceType.requests {
+			if matchName(message, req.Name(), req.id) {
+				return req
+			}
+		}
+	}
+	return nil
+}
+
+func matchName(s, name string, id uint16) bool {
+	if s == name {
+		return true
+	}
+	n, err := strconv.ParseUint(s, 10, 16)
+	return err == nil && uint16(n) == id
+}
+
+// gatewayCodec collects the messages sent by handler.
+type gatewayCodec struct {
+	mutex  sync.Mutex
+	sent   []Message
+	closed bool
+	remote string
+}
+
+func (c *gatewayCodec) Receive() (interface{}, error) {
+	return nil, io.EOF
+}
+
+func (c *gatewayCodec) Send(msg interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return link.SessionClosedError
+	}
+	m, _ := Untraced(msg)
+	c.sent = append(c.sent, m)
+	return nil
+}
+
+func (c *gatewayCodec) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *gatewayCodec) responses() []Message {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.sent
+}
@@ -0,0 +1,165 @@
+package fastapi
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/funny/link"
+)
+
+type testPanicService struct{}
+
+func (s *testPanicService) APIs() APIs {
+	return APIs{1: {testPanicEcho{}, testPanicEcho{}}}
+}
+
+func (s *testPanicService) ServiceID() uint16 {
+	return 3
+}
+
+func (s *testPanicService) NewRequest(id uint16) Message {
+	return &testPanicEcho{}
+}
+
+func (s *testPanicService) NewResponse(id uint16) Message {
+	return &testPanicEcho{}
+}
+
+func (s *testPanicService) HandleRequest(session *link.Session, req Message) {
+	panic("secret")
+}
+
+type testPanicEcho struct {
+	testEcho
+}
+
+func (m *testPanicEcho) ServiceID() uint16 { return 3 }
+func (m *testPanicEcho) Identity() string  { return "testPanicService.testPanicEcho" }
+
+type testGatewayHandler struct {
+	noHandler
+	initErr      error
+	transactions int
+}
+
+func (h *testGatewayHandler) InitSession(session *link.Session) error {
+	return h.initErr
+}
+
+func (h *testGatewayHandler) Transaction(session *link.Session, req Message, work func()) {
+	h.transactions++
+	h.noHandler.Transaction(session, req, work)
+}
+
+func testGatewayPost(gw *Gateway, path, body, remote string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	if remote != "" {
+		r.RemoteAddr = remote
+	}
+	gw.ServeHTTP(w, r)
+	return w
+}
+
+func TestGateway(t *testing.T) {
+	app := newTestApp()
+	app.Register(3, &testPanicService{})
+	handler := &testGatewayHandler{}
+	gw := app.NewGateway(handler)
+
+	w := testGatewayPost(gw, "/1/1", `{"Data":"aGk="}`, "")
+	if w.Code != http.StatusOK || w.Body.String() != `{"Data":"aGk="}` {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	if handler.transactions != 1 {
+		t.Fatalf("transactions = %d", handler.transactions)
+	}
+
+	// The panic is logged by the handler, not returned to client.
+	w = testGatewayPost(gw, "/3/1", `{}`, "")
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "secret") {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+
+	handler.initErr = errors.New("rejected")
+	w = testGatewayPost(gw, "/1/1", `{}`, "")
+	if w.Code != http.StatusForbidden || handler.transactions != 2 {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestGatewayRateLimit(t *testing.T) {
+	app := newTestApp()
+	app.SetRateLimit(1, 1, RateLimit{
+		Rate:   0.001,
+		Action: RateLimitReply,
+		Reply: func(req Message) Message {
+			return &testEcho{[]byte("slow")}
+		},
+	})
+	gw := app.NewGateway(nil)
+
+	if w := testGatewayPost(gw, "/1/1", `{}`, "10.0.0.1:1000"); w.Code != http.StatusNoContent && w.Code != http.StatusOK {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	w := testGatewayPost(gw, "/1/1", `{}`, "10.0.0.1:2000")
+	if w.Code != http.StatusTooManyRequests || w.Body.String() != `{"Data":"c2xvdw=="}` {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	if w := testGatewayPost(gw, "/1/1", `{}`, "10.0.0.2:1000"); w.Code == http.StatusTooManyRequests {
+		t.Fatal("another client limited")
+	}
+	if n := app.Stats().RateLimited; n != 1 {
+		t.Fatalf("RateLimited = %d", n)
+	}
+}
+
+type testGatewayCheck struct {
+	noHandler
+	check func(session *link.Session)
+}
+
+func (h *testGatewayCheck) Transaction(session *link.Session, req Message, work func()) {
+	h.check(session)
+	h.noHandler.Transaction(session, req, work)
+}
+
+func TestGatewaySessions(t *testing.T) {
+	app := newTestApp()
+	checked := false
+	gw := app.NewGateway(&testGatewayCheck{check: func(session *link.Session) {
+		checked = true
+		// The request has the session state but it's not an active session.
+		if identity := app.Identity(session); identity == nil || identity.Name != "bob" {
+			t.Errorf("unexpected identity: %+v", identity)
+		}
+		if app.Session(session.ID()) != nil {
+			t.Error("gateway request listed as session")
+		}
+		if n := atomic.LoadInt64(&app.metrics.sessions); n != 0 {
+			t.Errorf("gateway request counted as session: %d", n)
+		}
+	}})
+	gw.Authenticate = func(r *http.Request) (*Identity, error) {
+		return &Identity{Name: "bob"}, nil
+	}
+
+	if w := testGatewayPost(gw, "/1/1", `{}`, ""); w.Code != http.StatusOK {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	if !checked {
+		t.Fatal("transaction not called")
+	}
+	n := 0
+	app.requests.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	if n != 0 {
+		t.Fatalf("%d gateway requests not removed", n)
+	}
+}
@@ -0,0 +1,65 @@
+package fastapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/funny/fastapi"
+	"github.com/funny/fastapi/example/fastapi_toy/module1"
+)
+
+// TestSchemaGenerated checks the schema codec against the code generated by
+// fastbin for the example, it's an external test to import the example.
+func TestSchemaGenerated(t *testing.T) {
+	app := fastapi.New()
+	app.Register(1, &module1.Service{})
+	schema, err := app.Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schema.Services) != 1 {
+		t.Fatalf("unexpected services: %+v", schema.Services)
+	}
+	name := schema.Services[0].Name + ".AddReq"
+	_, req := schema.Request(name)
+	if req == nil {
+		t.Fatalf("%s not found", name)
+	}
+	_, rsp := schema.Response(1, req.ID)
+	if rsp == nil {
+		t.Fatalf("response of %s not found", name)
+	}
+
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(`{"A": 1, "B": -2}`)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	data, err := req.Type.Encode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addReq := &module1.AddReq{A: 1, B: -2}
+	expected := make([]byte, addReq.BinarySize())
+	addReq.MarshalPacket(expected)
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("encoded %x, generated %x", data, expected)
+	}
+
+	addRsp := &module1.AddRsp{C: -1}
+	packet := make([]byte, addRsp.BinarySize())
+	addRsp.MarshalPacket(packet)
+	decoded, err := rsp.Type.Decode(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := json.Marshal(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(text) != `{"C":-1}` {
+		t.Fatalf("unexpected decoded: %s", text)
+	}
+}
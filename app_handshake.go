@@ -0,0 +1,263 @@
+package fastapi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/funny/link"
+)
+
+const ProtocolVersion = 1
+
+// Feature bits are exchanged in handshake, a session only turns on the
+// features enabled by both sides.
+//
+// FeatureSequence is not set in App.Features, it's sent when the Header has
+// sequence field. The sequence field is removed from the header of sessions
+// that the remote doesn't have it.
+type Feature uint32
+
+const (
+	FeatureHeartbeat Feature = 1 << iota
+	FeatureFragment
+	FeatureSequence
+	FeatureCompress
+)
+
+type HandshakeError struct {
+	Reason string
+}
+
+func (handshakeError HandshakeError) Error() string {
+	return fmt.Sprintf("Handshake Error: %s", handshakeError.Reason)
+}
+
+const (
+	handshakeOK byte = iota
+	handshakeBadVersion
+	handshakeBadSchema
+)
+
+const handshakeMagic = "FAPI"
+
+// magic(4) + version(2) + features(4) + schema(8)
+const handshakeSize = 4 + 2 + 4 + 8
+
+type handshake struct {
+	Version  uint16
+	Features Feature
+	Schema   uint64
+}
+
+func (app *App) localHandshake() handshake {
+	features := app.Features &^ FeatureSequence
+	if app.Header.Sequence {
+		features |= FeatureSequence
+	}
+	if app.MaxMessageSize <= 0 {
+		features &^= FeatureFragment
+	}
+	if app.service(controlServiceID) != nil {
+		features &^= controlFeatures
+	}
+	return handshake{ProtocolVersion, features, app.schemaHashOrDefault()}
+}
+
+// The App created by Schema.NewApp() has no service, it uses the schema hash
+// of the exported App.
+func (app *App) schemaHashOrDefault() uint64 {
+	if app.schemaHash != 0 {
+		return app.schemaHash
+	}
+	return app.SchemaHash()
+}
+
+func (h *handshake) marshal(buf []byte) {
+	copy(buf, handshakeMagic)
+	binary.LittleEndian.PutUint16(buf[4:], h.Version)
+	binary.LittleEndian.PutUint32(buf[6:], uint32(h.Features))
+	binary.LittleEndian.PutUint64(buf[10:], h.Schema)
+}
+
+func (h *handshake) unmarshal(buf []byte) error {
+	if string(buf[:4]) != handshakeMagic {
+		return HandshakeError{"Not A fastapi Peer"}
+	}
+	h.Version = binary.LittleEndian.Uint16(buf[4:])
+	h.Features = Feature(binary.LittleEndian.Uint32(buf[6:]))
+	h.Schema = binary.LittleEndian.Uint64(buf[10:])
+	return nil
+}
+
+func (h *handshake) check(remote *handshake) byte {
+	if h.Version != remote.Version {
+		return handshakeBadVersion
+	}
+	if h.Schema != remote.Schema {
+		return handshakeBadSchema
+	}
+	return handshakeOK
+}
+
+func handshakeFailure(status byte, local, remote *handshake) error {
+	switch status {
+	case handshakeBadVersion:
+		return HandshakeError{fmt.Sprintf("Protocol Version Mismatch: local %d, remote %d", local.Version, remote.Version)}
+	case handshakeBadSchema:
+		return HandshakeError{fmt.Sprintf("Schema Mismatch: local %016x, remote %016x", local.Schema, remote.Schema)}
+	}
+	return HandshakeError{fmt.Sprintf("Rejected By Remote: %d", status)}
+}
+
+func (c *codec) setHandshakeDeadline() func() {
+	if c.app.HandshakeTimeout <= 0 {
+		return func() {}
+	}
+	c.conn.SetDeadline(time.Now().Add(c.app.HandshakeTimeout))
+	return func() {
+		c.conn.SetDeadline(time.Time{})
+	}
+}
+
+func (c *codec) clientHandshake() error {
+	defer c.setHandshakeDeadline()()
+
+	local := c.app.localHandshake()
+	var buf [handshakeSize + 1]byte
+
+	local.marshal(buf[:])
+	if _, err := c.conn.Write(buf[:handshakeSize]); err != nil {
+		return err
+	}
+
+	if _, err := io.ReadFull(c.reader, buf[:]); err != nil {
+		return err
+	}
+
+	var remote handshake
+	if err := remote.unmarshal(buf[:]); err != nil {
+		return err
+	}
+	if status := buf[handshakeSize]; status != handshakeOK {
+		return handshakeFailure(status, &local, &remote)
+	}
+
+	c.setFeatures(local.Features & remote.Features)
+	return nil
+}
+
+func (c *codec) serverHandshake() error {
+	defer c.setHandshakeDeadline()()
+
+	local := c.app.localHandshake()
+	var buf [handshakeSize + 1]byte
+
+	if _, err := io.ReadFull(c.reader, buf[:handshakeSize]); err != nil {
+		return err
+	}
+
+	var remote handshake
+	if err := remote.unmarshal(buf[:]); err != nil {
+		return err
+	}
+
+	status := local.check(&remote)
+	local.marshal(buf[:])
+	buf[handshakeSize] = status
+	if _, err := c.conn.Write(buf[:]); err != nil {
+		return err
+	}
+	if status != handshakeOK {
+		return handshakeFailure(status, &local, &remote)
+	}
+
+	c.setFeatures(local.Features & remote.Features)
+	return nil
+}
+
+func (c *codec) setFeatures(features Feature) {
+	c.features = features
+	if c.format.Sequence && features&FeatureSequence == 0 {
+		c.format.Sequence = false
+		c.headSize = c.format.Size()
+		c.headBuf = make([]byte, c.headSize)
+	}
+}
+
+// NegotiatedFeatures returns the features both sides of the session enabled.
+// It's zero without handshake, since the remote may be a legacy client.
+func NegotiatedFeatures(session *link.Session) Feature {
+	if c, ok := session.Codec().(*codec); ok {
+		return c.features
+	}
+	return 0
+}
+
+// SchemaHash is a fingerprint of the registered services and the field
+// layout of their messages, peers with different schema can't talk.
+func (app *App) SchemaHash() uint64 {
+	serviceTypes := make([]*ServiceType, len(app.serviceTypes))
+	copy(serviceTypes, app.serviceTypes)
+	sort.Slice(serviceTypes, func(i, j int) bool {
+		return serviceTypes[i].id < serviceTypes[j].id
+	})
+
+	h := fnv.New64a()
+	for _, service := range serviceTypes {
+		fmt.Fprintf(h, "service %d %s.%s\n", service.id, service.Package(), service.Name())
+		hashMessages(h, "request", service.requests)
+		hashMessages(h, "response", service.responses)
+	}
+	return h.Sum64()
+}
+
+func hashMessages(w io.Writer, kind string, messages []*MessageType) {
+	messages = append([]*MessageType(nil), messages...)
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].id < messages[j].id
+	})
+	for _, msg := range messages {
+		fmt.Fprintf(w, "%s %d %s ", kind, msg.id, msg.Name())
+		hashType(w, msg.t, 0)
+		fmt.Fprintln(w)
+	}
+}
+
+func hashType(w io.Writer, t reflect.Type, depth int) {
+	if depth > 8 {
+		fmt.Fprint(w, t.Kind())
+		return
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		fmt.Fprint(w, "*")
+		hashType(w, t.Elem(), depth+1)
+	case reflect.Slice:
+		fmt.Fprint(w, "[]")
+		hashType(w, t.Elem(), depth+1)
+	case reflect.Array:
+		fmt.Fprintf(w, "[%d]", t.Len())
+		hashType(w, t.Elem(), depth+1)
+	case reflect.Map:
+		fmt.Fprint(w, "map[")
+		hashType(w, t.Key(), depth+1)
+		fmt.Fprint(w, "]")
+		hashType(w, t.Elem(), depth+1)
+	case reflect.Struct:
+		fmt.Fprint(w, "{")
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			fmt.Fprintf(w, "%s ", field.Name)
+			hashType(w, field.Type, depth+1)
+			fmt.Fprint(w, ";")
+		}
+		fmt.Fprint(w, "}")
+	default:
+		fmt.Fprint(w, t.Kind())
+	}
+}
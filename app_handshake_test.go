@@ -0,0 +1,110 @@
+package fastapi
+
+import (
+	"bytes"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+type countConn struct {
+	net.Conn
+	written int64
+}
+
+func (c *countConn) Write(p []byte) (int, error) {
+	atomic.AddInt64(&c.written, int64(len(p)))
+	return c.Conn.Write(p)
+}
+
+func TestHandshakeSchemaMismatch(t *testing.T) {
+	server := newTestApp()
+	server.Handshake = true
+	listener := ListenPipe()
+	go server.NewServer(listener, nil).Serve()
+	defer listener.Close()
+
+	client := New()
+	client.Handshake = true
+	if _, err := client.DialPipe(listener); err == nil {
+		t.Fatal("handshake with different schema succeeded")
+	} else if _, ok := err.(HandshakeError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	client = newTestApp()
+	client.Handshake = true
+	session, err := client.DialPipe(listener)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	testRoundTrip(t, session, []byte("hello"))
+}
+
+func TestHandshakeSequence(t *testing.T) {
+	for _, c := range []struct {
+		server, client, negotiated bool
+	}{
+		{true, true, true},
+		{true, false, false},
+		{false, true, false},
+	} {
+		server := newTestApp()
+		server.Handshake = true
+		server.Header.Sequence = c.server
+		listener := ListenPipe()
+		go server.NewServer(listener, nil).Serve()
+
+		client := newTestApp()
+		client.Handshake = true
+		client.Header.Sequence = c.client
+		session, err := client.DialPipe(listener)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := NegotiatedFeatures(session)&FeatureSequence != 0; got != c.negotiated {
+			t.Fatalf("%+v: sequence negotiated %v", c, got)
+		}
+		testRoundTrip(t, session, []byte("hello"))
+		testRoundTrip(t, session, []byte("world"))
+		if _, seq := RecvHeader(session); (seq != 0) != c.negotiated {
+			t.Fatalf("%+v: received sequence %d", c, seq)
+		}
+		session.Close()
+		listener.Close()
+	}
+}
+
+func TestHandshakeCompress(t *testing.T) {
+	app := newTestApp()
+	app.Handshake = true
+	app.CompressThreshold = 1024
+
+	listener := ListenPipe()
+	go app.NewServer(listener, nil).Serve()
+	defer listener.Close()
+
+	conn, err := listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter := &countConn{Conn: conn}
+	session, err := app.Connect(counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	if NegotiatedFeatures(session)&FeatureCompress == 0 {
+		t.Fatal("compression not negotiated")
+	}
+
+	data := bytes.Repeat([]byte("fastapi "), 4096)
+	before := atomic.LoadInt64(&counter.written)
+	testRoundTrip(t, session, data)
+	if n := atomic.LoadInt64(&counter.written) - before; n >= int64(len(data)/2) {
+		t.Fatalf("message not compressed: %d bytes written for %d bytes", n, len(data))
+	}
+	testRoundTrip(t, session, []byte("small"))
+}
@@ -0,0 +1,218 @@
+package fastapi
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/funny/link"
+)
+
+// HeaderFormat describes the packet header layout on the wire:
+//
+//	length | service id | message id | flags (optional) | sequence (optional) |
+//	trace id + span id (optional)
+//
+// The length field counts the payload only, it doesn't include the header.
+// The trace fields are 16 bytes trace id and 8 bytes span id, see Span.
+type HeaderFormat struct {
+	LengthSize    int // 1, 2 or 4 bytes
+	ByteOrder     binary.ByteOrder
+	ServiceIDSize int // 1 or 2 bytes
+	MessageIDSize int // 1 or 2 bytes
+	Flags         bool
+	Sequence      bool
+	Trace         bool
+}
+
+// DefaultHeader is 4 bytes little endian length + 1 byte service id + 1 byte
+// message id.
+var DefaultHeader = HeaderFormat{
+	LengthSize:    4,
+	ByteOrder:     binary.LittleEndian,
+	ServiceIDSize: 1,
+	MessageIDSize: 1,
+}
+
+// WideHeader is DefaultHeader with 2 bytes service id and message id, it's
+// required when the App registered service or message id larger than 255.
+var WideHeader = HeaderFormat{
+	LengthSize:    4,
+	ByteOrder:     binary.LittleEndian,
+	ServiceIDSize: 2,
+	MessageIDSize: 2,
+}
+
+// FlagsMessage is implemented by messages set the flags field of the packet
+// header, it's ignored when the HeaderFormat doesn't have Flags. Fragments of
+// the message have the same flags.
+type FlagsMessage interface {
+	HeaderFlags() byte
+}
+
+func headerFlags(msg Message) byte {
+	if m, ok := msg.(FlagsMessage); ok {
+		return m.HeaderFlags()
+	}
+	return 0
+}
+
+type packetHead struct {
+	Size      int
+	ServiceID uint16
+	MessageID uint16
+	Flags     byte
+	Sequence  uint32
+	Trace     SpanContext
+}
+
+func (f *HeaderFormat) Size() int {
+	size := f.LengthSize + f.ServiceIDSize + f.MessageIDSize
+	if f.Flags {
+		size += 1
+	}
+	if f.Sequence {
+		size += 4
+	}
+	if f.Trace {
+		size += traceSize
+	}
+	return size
+}
+
+func (f *HeaderFormat) MaxLength() int {
+	if f.LengthSize >= 4 {
+		return 1<<31 - 1
+	}
+	return 1<<(8*uint(f.LengthSize)) - 1
+}
+
+func (f *HeaderFormat) validate() error {
+	if f.LengthSize != 1 && f.LengthSize != 2 && f.LengthSize != 4 {
+		return fmt.Errorf("fastapi: unsupported header length size %d", f.LengthSize)
+	}
+	if f.ServiceIDSize != 1 && f.ServiceIDSize != 2 {
+		return fmt.Errorf("fastapi: unsupported header service id size %d", f.ServiceIDSize)
+	}
+	if f.MessageIDSize != 1 && f.MessageIDSize != 2 {
+		return fmt.Errorf("fastapi: unsupported header message id size %d", f.MessageIDSize)
+	}
+	if f.ByteOrder == nil {
+		return fmt.Errorf("fastapi: missing header byte order")
+	}
+	return nil
+}
+
+// validateHeader checks the Header and the ids of registered messages fit in
+// it, so a too narrow Header fails the session at once instead of the sending
+// of messages.
+func (app *App) validateHeader() error {
+	if err := app.Header.validate(); err != nil {
+		return err
+	}
+	for _, serviceType := range app.serviceTypes {
+		for _, messages := range [][]*MessageType{serviceType.requests, serviceType.responses} {
+			for _, msg := range messages {
+				if !app.Header.fits(serviceType.id, msg.id) {
+					return fmt.Errorf("fastapi: id [%d, %d] of '%s' doesn't fit in header", serviceType.id, msg.id, msg.Name())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (f *HeaderFormat) sequenceOffset() int {
+	offset := f.LengthSize + f.idSize()
+	if f.Flags {
+		offset += 1
+	}
+	return offset
+}
+
+func (f *HeaderFormat) fits(serviceID, messageID uint16) bool {
+	return (f.ServiceIDSize == 2 || serviceID <= 0xFF) &&
+		(f.MessageIDSize == 2 || messageID <= 0xFF)
+}
+
+func (f *HeaderFormat) idSize() int {
+	return f.ServiceIDSize + f.MessageIDSize
+}
+
+func (f *HeaderFormat) putIDs(buf []byte, serviceID, messageID uint16) int {
+	n := f.putUint(buf, f.ServiceIDSize, uint32(serviceID))
+	return n + f.putUint(buf[n:], f.MessageIDSize, uint32(messageID))
+}
+
+func (f *HeaderFormat) getIDs(buf []byte) (serviceID, messageID uint16) {
+	serviceID = uint16(f.getUint(buf, f.ServiceIDSize))
+	messageID = uint16(f.getUint(buf[f.ServiceIDSize:], f.MessageIDSize))
+	return
+}
+
+func (f *HeaderFormat) encode(buf []byte, h *packetHead) {
+	n := f.putUint(buf, f.LengthSize, uint32(h.Size))
+	n += f.putIDs(buf[n:], h.ServiceID, h.MessageID)
+	if f.Flags {
+		buf[n] = h.Flags
+		n += 1
+	}
+	if f.Sequence {
+		f.ByteOrder.PutUint32(buf[n:], h.Sequence)
+		n += 4
+	}
+	if f.Trace {
+		n += copy(buf[n:], h.Trace.TraceID[:])
+		copy(buf[n:], h.Trace.SpanID[:])
+	}
+}
+
+func (f *HeaderFormat) decode(buf []byte, h *packetHead) {
+	h.Size = int(f.getUint(buf, f.LengthSize))
+	h.ServiceID, h.MessageID = f.getIDs(buf[f.LengthSize:])
+	n := f.LengthSize + f.idSize()
+	if f.Flags {
+		h.Flags = buf[n]
+		n += 1
+	}
+	if f.Sequence {
+		h.Sequence = f.ByteOrder.Uint32(buf[n:])
+		n += 4
+	}
+	if f.Trace {
+		n += copy(h.Trace.TraceID[:], buf[n:])
+		copy(h.Trace.SpanID[:], buf[n:])
+	}
+}
+
+func (f *HeaderFormat) putUint(buf []byte, size int, v uint32) int {
+	switch size {
+	case 1:
+		buf[0] = byte(v)
+	case 2:
+		f.ByteOrder.PutUint16(buf, uint16(v))
+	case 4:
+		f.ByteOrder.PutUint32(buf, v)
+	}
+	return size
+}
+
+func (f *HeaderFormat) getUint(buf []byte, size int) uint32 {
+	switch size {
+	case 1:
+		return uint32(buf[0])
+	case 2:
+		return uint32(f.ByteOrder.Uint16(buf))
+	case 4:
+		return f.ByteOrder.Uint32(buf)
+	}
+	return 0
+}
+
+// RecvHeader returns the flags and the sequence of the last packet received
+// by the session, they're zero when the header format doesn't have them.
+func RecvHeader(session *link.Session) (flags byte, sequence uint32) {
+	if c, ok := session.Codec().(*codec); ok {
+		return c.head.Flags, c.head.Sequence
+	}
+	return 0, 0
+}
@@ -0,0 +1,197 @@
+package fastapi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/funny/link"
+)
+
+type testFlagsEcho struct {
+	testEcho
+	flags byte
+}
+
+func (m *testFlagsEcho) HeaderFlags() byte { return m.flags }
+
+var legacyHeader = HeaderFormat{
+	LengthSize:    2,
+	ByteOrder:     binary.BigEndian,
+	ServiceIDSize: 1,
+	MessageIDSize: 2,
+	Flags:         true,
+	Sequence:      true,
+}
+
+func TestHeaderFormat(t *testing.T) {
+	for _, c := range []struct {
+		format HeaderFormat
+		size   int
+		head   packetHead
+	}{
+		{DefaultHeader, 6, packetHead{Size: 100000, ServiceID: 255, MessageID: 1}},
+		{WideHeader, 8, packetHead{Size: 1, ServiceID: 1000, MessageID: 65535}},
+		{legacyHeader, 10, packetHead{Size: 65535, ServiceID: 3, MessageID: 300, Flags: 0x81, Sequence: 0x01020304}},
+		{HeaderFormat{LengthSize: 1, ByteOrder: binary.LittleEndian, ServiceIDSize: 1, MessageIDSize: 1}, 3, packetHead{Size: 255, ServiceID: 1, MessageID: 2}},
+		{HeaderFormat{LengthSize: 4, ByteOrder: binary.BigEndian, ServiceIDSize: 2, MessageIDSize: 1, Trace: true}, 31, packetHead{
+			Size: 7, ServiceID: 2, MessageID: 3,
+			Trace: SpanContext{TraceID{1, 2, 3, 15: 16}, SpanID{1, 7: 8}},
+		}},
+	} {
+		if err := c.format.validate(); err != nil {
+			t.Fatal(err)
+		}
+		if size := c.format.Size(); size != c.size {
+			t.Fatalf("%+v: size %d, expected %d", c.format, size, c.size)
+		}
+		buf := make([]byte, c.format.Size())
+		c.format.encode(buf, &c.head)
+		var head packetHead
+		c.format.decode(buf, &head)
+		if head != c.head {
+			t.Fatalf("%+v: decoded %+v, expected %+v", c.format, head, c.head)
+		}
+	}
+}
+
+func TestHeaderValidate(t *testing.T) {
+	for _, format := range []HeaderFormat{
+		{LengthSize: 3, ByteOrder: binary.LittleEndian, ServiceIDSize: 1, MessageIDSize: 1},
+		{LengthSize: 4, ByteOrder: binary.LittleEndian, ServiceIDSize: 4, MessageIDSize: 1},
+		{LengthSize: 4, ByteOrder: binary.LittleEndian, ServiceIDSize: 1, MessageIDSize: 0},
+		{LengthSize: 4, ServiceIDSize: 1, MessageIDSize: 1},
+	} {
+		if format.validate() == nil {
+			t.Fatalf("%+v: invalid format passed", format)
+		}
+	}
+	if n := legacyHeader.MaxLength(); n != 65535 {
+		t.Fatalf("MaxLength = %d", n)
+	}
+}
+
+// The bytes on wire must match the legacy client layout exactly.
+func TestHeaderWire(t *testing.T) {
+	app := newTestApp()
+	app.Header = legacyHeader
+
+	local, remote := Pipe()
+	defer remote.Close()
+	session, err := app.Connect(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	if err := session.Send(&testFlagsEcho{testEcho{[]byte("hi")}, 0x5A}); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Send(&testEcho{[]byte("yo")}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{
+		0, 2, 1, 0, 1, 0x5A, 0, 0, 0, 1, 'h', 'i',
+		0, 2, 1, 0, 1, 0x00, 0, 0, 0, 2, 'y', 'o',
+	}
+	buf := make([]byte, len(expected))
+	if _, err := io.ReadFull(remote, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, expected) {
+		t.Fatalf("wire bytes % x, expected % x", buf, expected)
+	}
+
+	// Reply from the legacy client.
+	remote.Write([]byte{0, 3, 1, 0, 1, 0x07, 0, 0, 0, 9, 'a', 'b', 'c'})
+	rsp, err := session.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rsp.(*testEcho).Data) != "abc" {
+		t.Fatalf("unexpected response %q", rsp.(*testEcho).Data)
+	}
+	if flags, seq := RecvHeader(session); flags != 0x07 || seq != 9 {
+		t.Fatalf("RecvHeader = %x, %d", flags, seq)
+	}
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	for _, format := range []HeaderFormat{DefaultHeader, WideHeader, legacyHeader} {
+		app := newTestApp()
+		app.Header = format
+		session, err := app.Pipe(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testRoundTrip(t, session, []byte("hello"))
+		size := app.MaxSendSize
+		if max := format.MaxLength(); size > max {
+			size = max
+		}
+		testRoundTrip(t, session, make([]byte, size))
+		session.Close()
+	}
+}
+
+type testWideService struct{}
+
+func (s *testWideService) APIs() APIs {
+	return APIs{300: {testWideEcho{}, testWideEcho{}}}
+}
+
+func (s *testWideService) ServiceID() uint16 {
+	return 1000
+}
+
+func (s *testWideService) NewRequest(id uint16) Message {
+	return &testWideEcho{}
+}
+
+func (s *testWideService) NewResponse(id uint16) Message {
+	return &testWideEcho{}
+}
+
+func (s *testWideService) HandleRequest(session *link.Session, req Message) {
+	Send(session, req)
+}
+
+type testWideEcho struct {
+	testEcho
+}
+
+func (m *testWideEcho) ServiceID() uint16 { return 1000 }
+func (m *testWideEcho) MessageID() uint16 { return 300 }
+func (m *testWideEcho) Identity() string  { return "testWideService.testWideEcho" }
+
+func TestHeaderWideIDs(t *testing.T) {
+	app := newTestApp()
+	app.Register(1000, &testWideService{})
+
+	// The ids don't fit in the DefaultHeader.
+	local, remote := Pipe()
+	defer remote.Close()
+	if _, err := app.Connect(local); err == nil {
+		t.Fatal("narrow header accepted")
+	}
+
+	app.Header = WideHeader
+	session, err := app.Pipe(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	testRoundTrip(t, session, []byte("hello"))
+	if err := session.Send(&testWideEcho{testEcho{[]byte("wide")}}); err != nil {
+		t.Fatal(err)
+	}
+	rsp, err := session.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := rsp.(*testWideEcho); !ok || string(m.Data) != "wide" {
+		t.Fatalf("unexpected response %#v", rsp)
+	}
+}
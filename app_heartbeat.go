@@ -0,0 +1,56 @@
+package fastapi
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	"github.com/funny/link"
+)
+
+func (c *codec) startHeartbeat() {
+	if c.app.HeartbeatInterval <= 0 || c.features&FeatureHeartbeat == 0 {
+		return
+	}
+	c.heartbeat = true
+	c.lastRecv = time.Now().UnixNano()
+	go c.heartbeatLoop()
+}
+
+func (c *codec) heartbeatLoop() {
+	misses := c.app.HeartbeatMisses
+	if misses <= 0 {
+		misses = 1
+	}
+	idleTimeout := c.app.HeartbeatInterval * time.Duration(misses)
+
+	ticker := time.NewTicker(c.app.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closeChan:
+			return
+		case now := <-ticker.C:
+			if now.Sub(time.Unix(0, atomic.LoadInt64(&c.lastRecv))) > idleTimeout {
+				c.Close()
+				return
+			}
+			var payload [8]byte
+			binary.LittleEndian.PutUint64(payload[:], uint64(now.UnixNano()))
+			if c.sendControl(controlPing, payload[:]) != nil {
+				c.Close()
+				return
+			}
+		}
+	}
+}
+
+// RTT returns the round trip time measured by the latest heartbeat of the
+// session, it's zero before the first pong received.
+func RTT(session *link.Session) time.Duration {
+	if c, ok := session.Codec().(*codec); ok {
+		return time.Duration(atomic.LoadInt64(&c.rtt))
+	}
+	return 0
+}
@@ -0,0 +1,159 @@
+package fastapi
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+)
+
+type testService255 struct{}
+
+func (s *testService255) APIs() APIs {
+	return APIs{1: {testEcho255{}, testEcho255{}}}
+}
+
+func (s *testService255) ServiceID() uint16 {
+	return 255
+}
+
+func (s *testService255) NewRequest(id uint16) Message {
+	return &testEcho255{}
+}
+
+func (s *testService255) NewResponse(id uint16) Message {
+	return &testEcho255{}
+}
+
+func (s *testService255) HandleRequest(session *link.Session, req Message) {
+	session.Send(req)
+}
+
+type testEcho255 struct {
+	testEcho
+}
+
+func (m *testEcho255) ServiceID() uint16 { return 255 }
+
+func TestHeartbeat(t *testing.T) {
+	app := newTestApp()
+	app.Handshake = true
+	app.HeartbeatInterval = 5 * time.Millisecond
+
+	session, err := app.Pipe(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	// The pongs are handled by Receive().
+	go session.Receive()
+	for i := 0; RTT(session) == 0; i++ {
+		if i == 100 {
+			t.Fatal("no pong received")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHeartbeatWithoutHandshake(t *testing.T) {
+	app := newTestApp()
+	app.HeartbeatInterval = 5 * time.Millisecond
+
+	session, err := app.Pipe(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	if NegotiatedFeatures(session) != 0 {
+		t.Fatalf("features without handshake: %d", NegotiatedFeatures(session))
+	}
+	time.Sleep(30 * time.Millisecond)
+	testRoundTrip(t, session, []byte("hello"))
+	if RTT(session) != 0 {
+		t.Fatal("ping sent without handshake")
+	}
+}
+
+func TestService255(t *testing.T) {
+	for _, handshake := range []bool{false, true} {
+		app := New()
+		app.Register(255, &testService255{})
+		app.Handshake = handshake
+		app.HeartbeatInterval = 5 * time.Millisecond
+
+		session, err := app.Pipe(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if NegotiatedFeatures(session)&controlFeatures != 0 {
+			t.Fatalf("handshake %v: control features offered with service 255", handshake)
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		data := []byte("hello")
+		if err := session.Send(&testEcho255{testEcho{data}}); err != nil {
+			t.Fatal(err)
+		}
+		rsp, err := session.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(rsp.(*testEcho255).Data, data) {
+			t.Fatal("response mismatch")
+		}
+		session.Close()
+	}
+}
+
+// blockHandler blocks the session in Transaction until the gate opened.
+type blockHandler struct {
+	noHandler
+	gate chan struct{}
+}
+
+func (h *blockHandler) Transaction(session *link.Session, req Message, work func()) {
+	<-h.gate
+	h.noHandler.Transaction(session, req, work)
+}
+
+func TestHeartbeatIdleTimeout(t *testing.T) {
+	server := newTestApp()
+	server.Handshake = true
+	handler := &blockHandler{gate: make(chan struct{})}
+	defer close(handler.gate)
+	listener := ListenPipe()
+	go server.NewServer(listener, handler).Serve()
+	defer listener.Close()
+
+	client := newTestApp()
+	client.Handshake = true
+	client.HeartbeatInterval = 5 * time.Millisecond
+	client.HeartbeatMisses = 2
+	session, err := client.DialPipe(listener)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	// The server doesn't answer pings while the request is handled.
+	if err := session.Send(&testEcho{[]byte("slow")}); err != nil {
+		t.Fatal(err)
+	}
+	closed := make(chan struct{})
+	go func() {
+		for {
+			if _, err := session.Receive(); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("idle session not closed")
+	}
+}
@@ -0,0 +1,36 @@
+package fastapi
+
+import (
+	"encoding/json"
+)
+
+// CodecOption sets the options of session codec, it's passed to the methods
+// of App create sessions:
+//
+//	server := app.NewServer(listener, handler, fastapi.JSONPayload())
+//	session, err := app.Dial("tcp", address, fastapi.JSONPayload())
+type CodecOption func(*codec)
+
+// JSONPayload makes the session encode payloads by encoding/json instead of
+// fastbin, the packet header is not changed. Both sides of session must use
+// the same payload format.
+func JSONPayload() CodecOption {
+	return func(c *codec) {
+		c.jsonPayload = true
+	}
+}
+
+func marshalJSON(msg Message) ([]byte, error) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return nil, MarshalError{msg.Identity(), 0, err}
+	}
+	return payload, nil
+}
+
+func unmarshalJSON(msg Message, packet []byte) error {
+	if err := json.Unmarshal(packet, msg); err != nil {
+		return DecodeError{err}
+	}
+	return nil
+}
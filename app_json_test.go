@@ -0,0 +1,49 @@
+package fastapi
+
+import (
+	"io"
+	"testing"
+)
+
+func TestJSONPayload(t *testing.T) {
+	app := newTestApp()
+	app.Handshake = true
+	listener := ListenPipe()
+	go app.NewServer(listener, nil, JSONPayload()).Serve()
+	defer listener.Close()
+
+	// The option works with any wrapper of connection.
+	conn, err := listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := app.Connect(&countConn{Conn: conn}, JSONPayload())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	testRoundTrip(t, session, []byte("hello"))
+}
+
+func TestJSONPayloadWire(t *testing.T) {
+	app := newTestApp()
+	local, remote := Pipe()
+	defer remote.Close()
+	session, err := app.Connect(local, JSONPayload())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	if err := session.Send(&testEcho{[]byte("hi")}); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Data":"aGk="}`
+	buf := make([]byte, app.Header.Size()+len(expected))
+	if _, err := io.ReadFull(remote, buf); err != nil {
+		t.Fatal(err)
+	}
+	if payload := string(buf[app.Header.Size():]); payload != expected {
+		t.Fatalf("payload %s, expected %s", payload, expected)
+	}
+}
@@ -0,0 +1,80 @@
+package fastapi
+
+import (
+	"context"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"github.com/funny/link"
+)
+
+// MaxLogPayload limits the bytes of request payload dumped by slow request
+// logging.
+var MaxLogPayload = 1024
+
+// logRequest logs the handled request at debug level, the failed one at error
+// level, and the slow one at warn level with the details and payload dump.
+func (app *App) logRequest(session *link.Session, req Message, d time.Duration, result string) {
+	logger := app.Logger
+	if logger == nil {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("message", req.Identity()),
+		slog.Uint64("session", session.ID()),
+		slog.Duration("duration", d),
+		slog.String("result", result),
+	}
+
+	if app.SlowRequest > 0 && d >= app.SlowRequest {
+		attrs = append(attrs,
+			slog.String("service", app.serviceName(req.ServiceID())),
+			slog.Int("service_id", int(req.ServiceID())),
+			slog.Int("message_id", int(req.MessageID())),
+			slog.String("remote", remoteAddr(session)),
+		)
+		if identity := app.Identity(session); identity != nil {
+			attrs = append(attrs, slog.String("identity", identity.Name))
+		}
+		if span := app.CurrentSpan(session); span != nil {
+			attrs = append(attrs, slog.String("trace", span.TraceID.String()))
+		}
+		attrs = append(attrs, slog.String("request", DumpMessage(req)), slog.String("payload", dumpPayload(req)))
+		logger.LogAttrs(context.Background(), slog.LevelWarn, "fastapi: slow request", attrs...)
+		return
+	}
+
+	level := slog.LevelDebug
+	if app.DebugLog() {
+		level = slog.LevelInfo
+	}
+	if result != "ok" {
+		level = slog.LevelError
+		attrs = append(attrs, slog.String("request", DumpMessage(req)))
+	}
+	logger.LogAttrs(context.Background(), level, "fastapi: request", attrs...)
+}
+
+func dumpPayload(msg Message) string {
+	size := msg.BinarySize()
+	buf := make([]byte, size)
+	if err := marshal(msg, buf); err != nil {
+		return err.Error()
+	}
+	if size > MaxLogPayload {
+		return hex.EncodeToString(buf[:MaxLogPayload]) + "..."
+	}
+	return hex.EncodeToString(buf)
+}
+
+func remoteAddr(session *link.Session) string {
+	switch c := session.Codec().(type) {
+	case *codec:
+		return c.conn.RemoteAddr().String()
+	case *gatewayCodec:
+		return c.remote
+	}
+	return ""
+}
@@ -0,0 +1,73 @@
+package fastapi
+
+import (
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// lineWriter passes the log lines written by slog.JSONHandler to a channel.
+type lineWriter chan map[string]interface{}
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	var line map[string]interface{}
+	if err := json.Unmarshal(p, &line); err != nil {
+		return 0, err
+	}
+	w <- line
+	return len(p), nil
+}
+
+func testLogLine(t *testing.T, slow time.Duration) map[string]interface{} {
+	t.Helper()
+	lines := make(lineWriter, 10)
+	app := newTestApp()
+	app.Logger = slog.New(slog.NewJSONHandler(lines, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	app.SlowRequest = slow
+
+	listener := ListenPipe()
+	go app.NewServer(listener, nil).Serve()
+	defer listener.Close()
+
+	session, err := newTestApp().DialPipe(listener)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	testRoundTrip(t, session, []byte("hello"))
+
+	select {
+	case line := <-lines:
+		if line["message"] != "testService.testEcho" || line["result"] != "ok" {
+			t.Fatalf("unexpected line: %v", line)
+		}
+		return line
+	case <-time.After(time.Second):
+		t.Fatal("request not logged")
+	}
+	return nil
+}
+
+func TestLogRequest(t *testing.T) {
+	line := testLogLine(t, time.Hour)
+	if line["level"] != "DEBUG" || line["msg"] != "fastapi: request" {
+		t.Fatalf("unexpected line: %v", line)
+	}
+	if _, ok := line["payload"]; ok {
+		t.Fatalf("fast request has payload: %v", line)
+	}
+}
+
+func TestLogSlowRequest(t *testing.T) {
+	line := testLogLine(t, time.Nanosecond)
+	if line["level"] != "WARN" || line["msg"] != "fastapi: slow request" {
+		t.Fatalf("unexpected line: %v", line)
+	}
+	if line["service"] != "testService" || line["service_id"] != 1.0 || line["message_id"] != 1.0 {
+		t.Fatalf("missing details: %v", line)
+	}
+	if line["payload"] != "68656c6c6f" || line["remote"] == "" {
+		t.Fatalf("missing payload or remote: %v", line)
+	}
+}
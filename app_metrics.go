@@ -0,0 +1,184 @@
+package fastapi
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMetricsBuckets are the upper bounds in seconds of the request
+// latency histogram buckets.
+var DefaultMetricsBuckets = []float64{
+	0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5,
+}
+
+type metrics struct {
+	sessions int64
+	messages sync.Map // Identity() -> *messageMetrics
+}
+
+type messageMetrics struct {
+	service  string
+	identity string
+	requests uint64
+	errors   uint64
+	bytesIn  uint64
+	bytesOut uint64
+	sumNanos uint64
+	buckets  []uint64
+}
+
+func (app *App) messageMetrics(serviceID uint16, identity string) *messageMetrics {
+	if m, ok := app.metrics.messages.Load(identity); ok {
+		return m.(*messageMetrics)
+	}
+	m, _ := app.metrics.messages.LoadOrStore(identity, &messageMetrics{
+		service:  app.serviceName(serviceID),
+		identity: identity,
+		buckets:  make([]uint64, len(app.MetricsBuckets)),
+	})
+	return m.(*messageMetrics)
+}
+
+func (app *App) serviceName(serviceID uint16) string {
+	for _, serviceType := range app.serviceTypes {
+		if serviceType.id == serviceID {
+			return serviceType.Name()
+		}
+	}
+	return fmt.Sprint(serviceID)
+}
+
+func (app *App) recordRequest(req Message, d time.Duration, failed bool) {
+	if !app.EnableMetrics {
+		return
+	}
+	m := app.messageMetrics(req.ServiceID(), req.Identity())
+	atomic.AddUint64(&m.requests, 1)
+	atomic.AddUint64(&m.sumNanos, uint64(d))
+	if failed {
+		atomic.AddUint64(&m.errors, 1)
+	}
+	seconds := d.Seconds()
+	for i, bound := range app.MetricsBuckets {
+		if seconds <= bound {
+			atomic.AddUint64(&m.buckets[i], 1)
+			break
+		}
+	}
+}
+
+func (app *App) recordError(msg Message) {
+	if app.EnableMetrics {
+		atomic.AddUint64(&app.messageMetrics(msg.ServiceID(), msg.Identity()).errors, 1)
+	}
+}
+
+func (app *App) recordBytesIn(msg Message, size int) {
+	if app.EnableMetrics {
+		atomic.AddUint64(&app.messageMetrics(msg.ServiceID(), msg.Identity()).bytesIn, uint64(size))
+	}
+}
+
+func (app *App) recordBytesOut(msg Message, size int) {
+	if app.EnableMetrics {
+		atomic.AddUint64(&app.messageMetrics(msg.ServiceID(), msg.Identity()).bytesOut, uint64(size))
+	}
+}
+
+// WriteMetrics writes the metrics and the Stats counters in Prometheus text
+// exposition format.
+func (app *App) WriteMetrics(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	var messages []*messageMetrics
+	app.metrics.messages.Range(func(_, m interface{}) bool {
+		messages = append(messages, m.(*messageMetrics))
+		return true
+	})
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].identity < messages[j].identity
+	})
+
+	fmt.Fprintln(bw, "# HELP fastapi_sessions Number of active sessions.")
+	fmt.Fprintln(bw, "# TYPE fastapi_sessions gauge")
+	fmt.Fprintf(bw, "fastapi_sessions %d\n", atomic.LoadInt64(&app.metrics.sessions))
+
+	counters := []struct {
+		name, help string
+		value      func(*messageMetrics) uint64
+	}{
+		{"fastapi_requests_total", "Number of handled requests.", func(m *messageMetrics) uint64 { return atomic.LoadUint64(&m.requests) }},
+		{"fastapi_errors_total", "Number of failed requests and messages.", func(m *messageMetrics) uint64 { return atomic.LoadUint64(&m.errors) }},
+		{"fastapi_received_bytes_total", "Received payload bytes.", func(m *messageMetrics) uint64 { return atomic.LoadUint64(&m.bytesIn) }},
+		{"fastapi_sent_bytes_total", "Sent payload bytes.", func(m *messageMetrics) uint64 { return atomic.LoadUint64(&m.bytesOut) }},
+	}
+	for _, counter := range counters {
+		fmt.Fprintf(bw, "# HELP %s %s\n", counter.name, counter.help)
+		fmt.Fprintf(bw, "# TYPE %s counter\n", counter.name)
+		for _, m := range messages {
+			fmt.Fprintf(bw, "%s{%s} %d\n", counter.name, m.labels(), counter.value(m))
+		}
+	}
+
+	fmt.Fprintln(bw, "# HELP fastapi_request_duration_seconds Request handling latency.")
+	fmt.Fprintln(bw, "# TYPE fastapi_request_duration_seconds histogram")
+	for _, m := range messages {
+		labels := m.labels()
+		var count uint64
+		for i, bound := range app.MetricsBuckets {
+			count += atomic.LoadUint64(&m.buckets[i])
+			fmt.Fprintf(bw, "fastapi_request_duration_seconds_bucket{%s,le=\"%g\"} %d\n", labels, bound, count)
+		}
+		requests := atomic.LoadUint64(&m.requests)
+		fmt.Fprintf(bw, "fastapi_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, requests)
+		fmt.Fprintf(bw, "fastapi_request_duration_seconds_sum{%s} %g\n", labels, time.Duration(atomic.LoadUint64(&m.sumNanos)).Seconds())
+		fmt.Fprintf(bw, "fastapi_request_duration_seconds_count{%s} %d\n", labels, requests)
+	}
+
+	// Stats counters are exported as fastapi_stats_<snake_case>_total.
+	stats := reflect.ValueOf(app.Stats())
+	for i := 0; i < stats.NumField(); i++ {
+		name := "fastapi_stats_" + snakeCase(stats.Type().Field(i).Name) + "_total"
+		fmt.Fprintf(bw, "# TYPE %s counter\n", name)
+		fmt.Fprintf(bw, "%s %d\n", name, stats.Field(i).Uint())
+	}
+
+	return bw.Flush()
+}
+
+// MetricsHandler serves WriteMetrics() over HTTP for Prometheus scraping.
+func (app *App) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		app.WriteMetrics(w)
+	})
+}
+
+func (m *messageMetrics) labels() string {
+	return fmt.Sprintf("service=%q,message=%q", m.service, m.identity)
+}
+
+func snakeCase(name string) string {
+	var b strings.Builder
+	var prev rune
+	for _, r := range name {
+		if r >= 'A' && r <= 'Z' {
+			if prev >= 'a' && prev <= 'z' {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r + 'a' - 'A')
+		} else {
+			b.WriteRune(r)
+		}
+		prev = r
+	}
+	return b.String()
+}
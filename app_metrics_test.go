@@ -0,0 +1,70 @@
+package fastapi
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	app := newTestApp()
+	app.EnableMetrics = true
+	app.MetricsBuckets = []float64{0.5, 1}
+
+	listener := ListenPipe()
+	go app.NewServer(listener, nil).Serve()
+	defer listener.Close()
+
+	// The client has its own App so only the server side is measured.
+	session, err := newTestApp().DialPipe(listener)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	testRoundTrip(t, session, []byte("hello"))
+	testRoundTrip(t, session, []byte("world!"))
+
+	// Requests are recorded after HandleRequest returns.
+	time.Sleep(50 * time.Millisecond)
+
+	var buf bytes.Buffer
+	if err := app.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	output := buf.String()
+
+	labels := `service="testService",message="testService.testEcho"`
+	for _, line := range []string{
+		"# TYPE fastapi_sessions gauge",
+		"fastapi_sessions 1",
+		"# TYPE fastapi_requests_total counter",
+		"fastapi_requests_total{" + labels + "} 2",
+		"fastapi_errors_total{" + labels + "} 0",
+		"fastapi_received_bytes_total{" + labels + "} 11",
+		"fastapi_sent_bytes_total{" + labels + "} 11",
+		"# TYPE fastapi_request_duration_seconds histogram",
+		"fastapi_request_duration_seconds_bucket{" + labels + `,le="0.5"} 2`,
+		"fastapi_request_duration_seconds_bucket{" + labels + `,le="1"} 2`,
+		"fastapi_request_duration_seconds_bucket{" + labels + `,le="+Inf"} 2`,
+		"fastapi_request_duration_seconds_count{" + labels + "} 2",
+		"# TYPE fastapi_stats_access_denied_total counter",
+		"fastapi_stats_access_denied_total 0",
+	} {
+		if !strings.Contains(output, line+"\n") {
+			t.Fatalf("missing %q in:\n%s", line, output)
+		}
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for name, expected := range map[string]string{
+		"AccessDenied": "access_denied",
+		"SendDropped":  "send_dropped",
+		"Panics":       "panics",
+	} {
+		if s := snakeCase(name); s != expected {
+			t.Fatalf("snakeCase(%q) = %q", name, s)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package fastapi
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+)
+
+// PipeListener is an in-memory net.Listener, every Dial() creates a Pipe()
+// and hands the server side to Accept(). It lets an App serve sessions in the
+// same process without binding any port.
+type PipeListener struct {
+	conns     chan net.Conn
+	closeChan chan struct{}
+	closeOnce sync.Once
+}
+
+func ListenPipe() *PipeListener {
+	return &PipeListener{
+		conns:     make(chan net.Conn),
+		closeChan: make(chan struct{}),
+	}
+}
+
+func (l *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closeChan:
+		return nil, io.ErrClosedPipe
+	}
+}
+
+func (l *PipeListener) Dial() (net.Conn, error) {
+	serverConn, clientConn := Pipe()
+	select {
+	case l.conns <- serverConn:
+		return clientConn, nil
+	case <-l.closeChan:
+		serverConn.Close()
+		clientConn.Close()
+		return nil, io.ErrClosedPipe
+	}
+}
+
+func (l *PipeListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closeChan)
+	})
+	return nil
+}
+
+func (l *PipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string {
+	return "pipe"
+}
+
+func (pipeAddr) String() string {
+	return "pipe"
+}
+
+// DialPipe connects a client session to the server accepting sessions from
+// the listener, e.g. app.NewServer(listener, handler).
+func (app *App) DialPipe(listener *PipeListener, opts ...CodecOption) (*link.Session, error) {
+	conn, err := listener.Dial()
+	if err != nil {
+		return nil, err
+	}
+	return app.Connect(conn, opts...)
+}
+
+// Pipe connects a client session to a server session through Pipe().
+// The server session is dispatched by the App like any accepted connection
+// and it's closed when the client session is closed.
+// The client session is not recorded by the App.Recorder, the server session
+// has the same packets.
+// The options are applied to both sessions.
+func (app *App) Pipe(handler Handler, opts ...CodecOption) (*link.Session, error) {
+	conn, err := app.PipeDialer(handler, opts...)()
+	if err != nil {
+		return nil, err
+	}
+	session, err := app.Connect(conn, opts...)
+	if err != nil {
+		return nil, err
+	}
+	SetRecorder(session, nil)
+	return session, nil
+}
+
+// Pipe is like net.Pipe but writes never block, the written data is buffered
+// until the other side reads it, just like a socket with unlimited buffer.
+// Unbuffered net.Pipe deadlocks when both sides send something at the same
+// time, e.g. a heartbeat while the remote is sending a response.
+func Pipe() (net.Conn, net.Conn) {
+	a := newPipeBuffer()
+	b := newPipeBuffer()
+	return &pipeConn{r: a, w: b}, &pipeConn{r: b, w: a}
+}
+
+type pipeBuffer struct {
+	mutex    sync.Mutex
+	data     []byte
+	closed   bool
+	deadline time.Time
+	notify   chan struct{}
+}
+
+func newPipeBuffer() *pipeBuffer {
+	return &pipeBuffer{
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (b *pipeBuffer) wakeup() {
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (b *pipeBuffer) read(p []byte) (int, error) {
+	for {
+		b.mutex.Lock()
+		if len(b.data) > 0 {
+			n := copy(p, b.data)
+			b.data = b.data[n:]
+			b.mutex.Unlock()
+			return n, nil
+		}
+		if b.closed {
+			b.mutex.Unlock()
+			return 0, io.EOF
+		}
+		deadline := b.deadline
+		b.mutex.Unlock()
+
+		if deadline.IsZero() {
+			<-b.notify
+			continue
+		}
+		d := time.Until(deadline)
+		if d <= 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(d)
+		select {
+		case <-b.notify:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (b *pipeBuffer) write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	b.data = append(b.data, p...)
+	b.wakeup()
+	return len(p), nil
+}
+
+func (b *pipeBuffer) close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	b.wakeup()
+}
+
+func (b *pipeBuffer) setDeadline(t time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.deadline = t
+	b.wakeup()
+}
+
+type pipeConn struct {
+	r *pipeBuffer
+	w *pipeBuffer
+}
+
+func (c *pipeConn) Read(p []byte) (int, error) {
+	return c.r.read(p)
+}
+
+func (c *pipeConn) Write(p []byte) (int, error) {
+	return c.w.write(p)
+}
+
+func (c *pipeConn) Close() error {
+	c.r.close()
+	c.w.close()
+	return nil
+}
+
+func (c *pipeConn) LocalAddr() net.Addr {
+	return pipeAddr{}
+}
+
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return pipeAddr{}
+}
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	c.r.setDeadline(t)
+	return nil
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.r.setDeadline(t)
+	return nil
+}
+
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,119 @@
+package fastapi
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPipeListener(t *testing.T) {
+	app := newTestApp()
+	listener := ListenPipe()
+	server := app.NewServer(listener, nil)
+	go server.Serve()
+	defer server.Stop()
+
+	for i := 0; i < 3; i++ {
+		session, err := app.DialPipe(listener)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testRoundTrip(t, session, []byte("hello"))
+		testRoundTrip(t, session, nil)
+		session.Close()
+	}
+
+	listener.Close()
+	if _, err := app.DialPipe(listener); err == nil {
+		t.Fatal("dial closed listener succeeded")
+	}
+}
+
+func TestPipe(t *testing.T) {
+	app := newTestApp()
+	session, err := app.Pipe(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	testRoundTrip(t, session, []byte("hello"))
+	testRoundTrip(t, session, make([]byte, 32*1024))
+}
+
+func TestPipeDeadline(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	a.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := a.Read(make([]byte, 1)); err != os.ErrDeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	a.SetReadDeadline(time.Time{})
+	go b.Write([]byte("x"))
+	buf := make([]byte, 1)
+	if n, err := a.Read(buf); err != nil || n != 1 || buf[0] != 'x' {
+		t.Fatalf("read failed: %d %v", n, err)
+	}
+
+	b.Close()
+	if _, err := a.Read(buf); err == nil {
+		t.Fatal("read closed pipe succeeded")
+	}
+}
+
+func TestUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.sock")
+
+	app := newTestApp()
+	server, err := app.ListenUnix(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+
+	session, err := app.DialUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRoundTrip(t, session, []byte("hello"))
+	session.Close()
+
+	if _, err := app.ListenUnix(path, nil); err == nil {
+		t.Fatal("listen on the socket of running server succeeded")
+	}
+	server.Stop()
+}
+
+func TestUnixStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.sock")
+
+	// A crashed process leaves the socket file behind.
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.SetUnlinkOnClose(false)
+	listener.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+
+	app := newTestApp()
+	server, err := app.ListenUnix(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	defer server.Stop()
+
+	session, err := app.DialUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	testRoundTrip(t, session, []byte("hello"))
+}
@@ -0,0 +1,42 @@
+package fastapi
+
+type Priority int8
+
+const (
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	PriorityHigh
+)
+
+const numPriorities = 3
+
+// PriorityMessage is implemented by messages not in PriorityNormal, the code
+// generator implements it for message types declared with SetPriority().
+//
+// Priority applies to the sessions when SendChanSize > 0. The sessions of
+// FastwayServer only queue the messages sent by fastapi.Send(), which the
+// generated handlers use, the messages sent by session.Send() are handed to
+// fastway at once.
+type PriorityMessage interface {
+	Priority() Priority
+}
+
+func SetPriority(priority Priority) MessageOption {
+	return func(msg *MessageType) {
+		msg.priority = priority
+	}
+}
+
+// priorityLevel maps the message priority to index of sendQueue.levels.
+func priorityLevel(msg Message) int {
+	priority := PriorityNormal
+	if m, ok := msg.(PriorityMessage); ok {
+		priority = m.Priority()
+	}
+	if priority > PriorityHigh {
+		priority = PriorityHigh
+	} else if priority < PriorityLow {
+		priority = PriorityLow
+	}
+	return int(PriorityHigh - priority)
+}
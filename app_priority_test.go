@@ -0,0 +1,104 @@
+package fastapi
+
+import (
+	"io"
+	"runtime"
+	"testing"
+
+	"github.com/funny/link"
+)
+
+type testHighEcho struct {
+	testEcho
+}
+
+func (m *testHighEcho) Priority() Priority { return PriorityHigh }
+
+func TestPrioritySequence(t *testing.T) {
+	for _, batchSize := range []int{0, 4096} {
+		app := newTestApp()
+		app.Header = legacyHeader
+		app.SendBatchSize = batchSize
+
+		local, remote := Pipe()
+		conn := &gateConn{local, make(chan struct{})}
+		session, err := app.Connect(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The first one is blocked in writing, the others are queued.
+		session.Send(&testEcho{[]byte("1")})
+		for QueueDepth(session) != 0 {
+			runtime.Gosched()
+		}
+		session.Send(&testEcho{[]byte("2")})
+		session.Send(&testEcho{[]byte("3")})
+		session.Send(&testHighEcho{testEcho{[]byte("4")}})
+		close(conn.gate)
+
+		head := make([]byte, legacyHeader.Size())
+		for i, expected := range []string{"1", "4", "2", "3"} {
+			var h packetHead
+			if _, err := io.ReadFull(remote, head); err != nil {
+				t.Fatal(err)
+			}
+			legacyHeader.decode(head, &h)
+			payload := make([]byte, h.Size)
+			if _, err := io.ReadFull(remote, payload); err != nil {
+				t.Fatal(err)
+			}
+			if string(payload) != expected || h.Sequence != uint32(i+1) {
+				t.Fatalf("batch %d: packet #%d is %q seq %d, expected %q seq %d", batchSize, i, payload, h.Sequence, expected, i+1)
+			}
+		}
+		session.Close()
+		remote.Close()
+	}
+}
+
+// frameCodec is the codec of fastway session, it records the frames and
+// blocks sending until the gate opened.
+type frameCodec struct {
+	gate   chan struct{}
+	frames chan []byte
+}
+
+func (c *frameCodec) Receive() (interface{}, error) { select {} }
+func (c *frameCodec) Close() error                  { return nil }
+
+func (c *frameCodec) Send(msg interface{}) error {
+	<-c.gate
+	c.frames <- msg.(encodedFrame)
+	return nil
+}
+
+func TestPriorityFastway(t *testing.T) {
+	app := newTestApp()
+	c := &frameCodec{make(chan struct{}), make(chan []byte, 5)}
+	session := link.NewSession(c, 0)
+	sender := app.newFastwaySender(session)
+	defer sender.Close()
+
+	// The first one is blocked in fastway, the others are queued.
+	Send(session, &testEcho{[]byte("1")})
+	for QueueDepth(session) != 0 {
+		runtime.Gosched()
+	}
+	Send(session, &testEcho{[]byte("2")})
+	Send(session, &testEcho{[]byte("3")})
+	Send(session, &testHighEcho{testEcho{[]byte("4")}})
+	// The priority of traced messages is the priority of wrapped message.
+	sender.Send(tracedMessage{&testHighEcho{testEcho{[]byte("5")}}, StartTrace()})
+	close(c.gate)
+
+	for i, expected := range []string{"1", "4", "5", "2", "3"} {
+		msg, err := sender.format.DecodeMessage(<-c.frames)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data := string(msg.(*testEcho).Data); data != expected {
+			t.Fatalf("frame #%d is %q, expected %q", i, data, expected)
+		}
+	}
+}
@@ -2,37 +2,103 @@ package fastapi
 
 import (
 	"bufio"
-	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/funny/link"
 )
 
-func (app *App) newClientCodec(rw io.ReadWriter) (link.Codec, error) {
-	return app.newCodec(rw, app.newResponse), nil
+func (app *App) newClientCodec(rw io.ReadWriter, opts ...CodecOption) (link.Codec, error) {
+	c, err := app.newClientCodecWith(rw, app.newResponse, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (app *App) newClientCodecWith(rw io.ReadWriter, newMessage func(uint16, uint16) (Message, error), opts ...CodecOption) (*codec, error) {
+	c, err := app.newCodec(rw, newMessage, opts)
+	if err != nil {
+		return nil, err
+	}
+	if app.Handshake {
+		if err := c.clientHandshake(); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	c.startHeartbeat()
+	return c, nil
 }
 
-func (app *App) newServerCodec(rw io.ReadWriter) (link.Codec, error) {
-	return app.newCodec(rw, app.newRequest), nil
+func (app *App) newServerCodec(rw io.ReadWriter, opts ...CodecOption) (link.Codec, error) {
+	c, err := app.newCodec(rw, app.newRequest, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.side = ServerSide
+	if app.Handshake {
+		if err := c.serverHandshake(); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	c.startHeartbeat()
+	return c, nil
 }
 
-func (app *App) newCodec(rw io.ReadWriter, newMessage func(byte, byte) (Message, error)) link.Codec {
+func (app *App) newCodec(rw io.ReadWriter, newMessage func(uint16, uint16) (Message, error), opts []CodecOption) (*codec, error) {
+	if err := app.validateHeader(); err != nil {
+		rw.(net.Conn).Close()
+		return nil, err
+	}
+	conn := rw.(net.Conn)
+	var release func()
+	if admitted, ok := conn.(*admittedConn); ok {
+		conn, release = admitted.Conn, admitted.release
+	}
 	c := &codec{
-		app:        app,
-		conn:       rw.(net.Conn),
-		reader:     bufio.NewReaderSize(rw, app.ReadBufSize),
-		newMessage: newMessage,
+		app:         app,
+		conn:        conn,
+		release:     release,
+		reader:      bufio.NewReaderSize(conn, app.ReadBufSize),
+		newMessage:  newMessage,
+		closeChan:   make(chan struct{}),
+		format:      app.Header,
+		headSize:    app.Header.Size(),
+		maxSendSize: app.MaxSendSize,
+	}
+	c.headBuf = make([]byte, c.headSize)
+	for _, opt := range opts {
+		opt(c)
 	}
-	c.headBuf = c.headData[:]
-	return c
+	if app.Recorder != nil {
+		c.recorder.Store(app.Recorder)
+	}
+	if max := c.format.MaxLength(); c.maxSendSize > max {
+		c.maxSendSize = max
+	}
+	if app.SendChanSize > 0 {
+		c.queue = newSendQueue(app.SendChanSize)
+		go c.sendLoop()
+	}
+	return c, nil
 }
 
-func (app *App) newRequest(serviceID, messageID byte) (Message, error) {
-	if service := app.services[serviceID]; service != nil {
-		if msg := service.(Service).NewRequest(messageID); msg != nil {
+func (app *App) service(serviceID uint16) Service {
+	if int(serviceID) < len(app.services) && app.services[serviceID] != nil {
+		return app.services[serviceID].(Service)
+	}
+	return nil
+}
+
+func (app *App) newRequest(serviceID, messageID uint16) (Message, error) {
+	if service := app.service(serviceID); service != nil {
+		if msg := service.NewRequest(messageID); msg != nil {
 			return msg, nil
 		}
 		return nil, DecodeError{fmt.Sprintf("Unsupported Message Type: [%d, %d]", serviceID, messageID)}
@@ -40,9 +106,9 @@ func (app *App) newRequest(serviceID, messageID byte) (Message, error) {
 	return nil, DecodeError{fmt.Sprintf("Unsupported Service: [%d, %d]", serviceID, messageID)}
 }
 
-func (app *App) newResponse(serviceID, messageID byte) (Message, error) {
-	if service := app.services[serviceID]; service != nil {
-		if msg := service.(Service).NewResponse(messageID); msg != nil {
+func (app *App) newResponse(serviceID, messageID uint16) (Message, error) {
+	if service := app.service(serviceID); service != nil {
+		if msg := service.NewResponse(messageID); msg != nil {
 			return msg, nil
 		}
 		return nil, DecodeError{fmt.Sprintf("Unsupported Message Type: [%d, %d]", serviceID, messageID)}
@@ -50,15 +116,34 @@ func (app *App) newResponse(serviceID, messageID byte) (Message, error) {
 	return nil, DecodeError{fmt.Sprintf("Unsupported Service: [%d, %d]", serviceID, messageID)}
 }
 
-const packetHeadSize = 4 + 2
-
 type codec struct {
-	app        *App
-	headBuf    []byte
-	headData   [packetHeadSize]byte
-	conn       net.Conn
-	reader     *bufio.Reader
-	newMessage func(byte, byte) (Message, error)
+	app         *App
+	format      HeaderFormat
+	headSize    int
+	headBuf     []byte
+	head        packetHead
+	conn        net.Conn
+	reader      *bufio.Reader
+	newMessage  func(uint16, uint16) (Message, error)
+	features    Feature
+	maxSendSize int
+	sendSeq     uint32
+	sendMutex   sync.Mutex
+	closeOnce   sync.Once
+	closeChan   chan struct{}
+	heartbeat   bool
+	lastRecv    int64
+	rtt         int64
+	side        Side
+	recorder    atomic.Pointer[Recorder]
+	fragments   fragments
+	queue       *sendQueue
+	batch       [][]byte
+	buffers     net.Buffers
+	retained    []byte
+	jsonPayload bool
+	session     *link.Session
+	release     func()
 }
 
 func (c *codec) Conn() net.Conn {
@@ -66,98 +151,333 @@ func (c *codec) Conn() net.Conn {
 }
 
 func (c *codec) Receive() (msg interface{}, err error) {
-	if c.app.RecvTimeout > 0 {
+	if c.app.RecvTimeout > 0 && !c.heartbeat {
 		c.conn.SetReadDeadline(time.Now().Add(c.app.RecvTimeout))
 		defer c.conn.SetReadDeadline(time.Time{})
 	}
 
-	if _, err = io.ReadFull(c.reader, c.headBuf); err != nil {
-		return
-	}
+	c.releasePacket()
 
-	packetSize := int(binary.LittleEndian.Uint32(c.headBuf))
+	for {
+		if _, err = io.ReadFull(c.reader, c.headBuf); err != nil {
+			return
+		}
 
-	if packetSize > c.app.MaxRecvSize {
-		return nil, DecodeError{fmt.Sprintf("Too Large Receive Packet Size: %d", packetSize)}
-	}
+		if c.heartbeat {
+			atomic.StoreInt64(&c.lastRecv, time.Now().UnixNano())
+		}
+
+		c.format.decode(c.headBuf, &c.head)
+		packetSize := c.head.Size
+
+		if packetSize > c.app.MaxRecvSize {
+			return nil, DecodeError{fmt.Sprintf("Too Large Receive Packet Size: %d", packetSize)}
+		}
+
+		packet := c.app.Pool.Alloc(packetSize)
+
+		if _, err = io.ReadFull(c.reader, packet); err != nil {
+			c.app.Pool.Free(packet)
+			return
+		}
 
-	packet := c.app.Pool.Alloc(packetSize)
+		if c.head.ServiceID == controlServiceID && c.features&controlFeatures != 0 {
+			var msg1 Message
+			msg1, err = c.handleControl(byte(c.head.MessageID), packet)
+			c.app.Pool.Free(packet)
+			if err != nil {
+				return
+			}
+			if msg1 != nil {
+				msg = msg1
+				return
+			}
+			continue
+		}
+
+		c.record(Inbound, c.head.ServiceID, c.head.MessageID, packet)
 
-	if _, err = io.ReadFull(c.reader, packet); err == nil {
-		msg1, err1 := c.newMessage(c.headData[4], c.headData[5])
-		if err1 == nil {
-			func() {
-				defer func() {
-					if panicErr := recover(); panicErr != nil {
-						err = DecodeError{panicErr}
-					}
-				}()
-				msg1.UnmarshalPacket(packet)
-			}()
+		var msg1 Message
+		if msg1, err = c.decode(c.head.ServiceID, c.head.MessageID, packet); err == nil {
+			c.app.recordBytesIn(msg1, packetSize)
 			msg = msg1
-		} else {
-			err = err1
 		}
+
+		c.freePacket(msg1, packet)
+		return
 	}
+}
 
-	c.app.Pool.Free(packet)
+func (c *codec) decode(serviceID, messageID uint16, packet []byte) (msg Message, err error) {
+	msg, err = c.newMessage(serviceID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			msg = nil
+			err = DecodeError{panicErr}
+		}
+	}()
+	if c.jsonPayload {
+		if err = unmarshalJSON(msg, packet); err != nil {
+			msg = nil
+		}
+		return
+	}
+	if m, ok := msg.(NoCopyUnmarshaler); ok && c.app.RetainRecvPacket {
+		m.UnmarshalPacketNoCopy(packet)
+	} else {
+		msg.UnmarshalPacket(packet)
+	}
 	return
 }
 
-func (c *codec) Send(m interface{}) (err error) {
-	msg := m.(Message)
+// Send encodes the message in caller's goroutine, so the size limit and the
+// marshal failure are reported by the return value of session.Send(). The
+// encoded packet is queued when SendChanSize > 0 and written by sendLoop.
+func (c *codec) Send(m interface{}) error {
+	msg, trace := c.traceOf(m.(Message))
+	packet, err := c.encode(msg, packetHead{Flags: headerFlags(msg), Trace: trace})
+	if err != nil {
+		return err
+	}
+
+	if c.queue == nil {
+		err = c.write(packet)
+		c.app.Pool.Free(packet)
+		return err
+	}
+
+	level := priorityLevel(msg)
+	if c.queue.Push(level, packet) {
+		return nil
+	}
+	return c.overflow(level, packet)
+}
+
+// Send is session.Send() without closing the session when the message can't
+// be encoded, the SizeError, MarshalError or EncodeError is returned and the
+// message is dropped. Other errors close the session like session.Send().
+// The messages sent to fastway server sessions are queued by priority.
+// The generated handlers send responses by it.
+func Send(session *link.Session, msg Message) error {
+	c, ok := session.Codec().(*codec)
+	if !ok {
+		if s, ok := fastwaySenders.Load(session); ok {
+			return s.(*fastwaySender).Send(msg)
+		}
+		return session.Send(msg)
+	}
+	if session.IsClosed() {
+		return link.SessionClosedError
+	}
+	err := c.Send(msg)
+	switch err.(type) {
+	case nil, SizeError, MarshalError, EncodeError:
+		return err
+	}
+	session.Close()
+	return err
+}
+
+func bindSession(session *link.Session) *link.Session {
+	if c, ok := session.Codec().(*codec); ok {
+		c.session = session
+	}
+	return session
+}
 
-	packetSize := msg.BinarySize()
+func (c *codec) traceOf(msg Message) (Message, SpanContext) {
+	if t, ok := msg.(tracedMessage); ok {
+		return t.Message, t.trace
+	}
+	if c.format.Trace && c.session != nil {
+		return msg, c.app.currentTrace(c.session)
+	}
+	return msg, SpanContext{}
+}
 
-	if packetSize > c.app.MaxSendSize {
-		panic(EncodeError{fmt.Sprintf("Too Large Send Packet Size: %d", packetSize)})
+// encode marshals the message into packet, the head has the flags and the
+// trace of message, other fields are set by encode.
+func (c *codec) encode(msg Message, head packetHead) (packet []byte, err error) {
+	if !c.format.fits(msg.ServiceID(), msg.MessageID()) {
+		return nil, EncodeError{fmt.Sprintf("Message ID Out Of Header Range: '%s' [%d, %d]", msg.Identity(), msg.ServiceID(), msg.MessageID())}
 	}
 
-	packet := c.app.Pool.Alloc(packetHeadSize + packetSize)
-	binary.LittleEndian.PutUint32(packet, uint32(packetSize))
-	packet[4] = msg.ServiceID()
-	packet[5] = msg.MessageID()
+	// JSON payload is marshaled before allocating the packet, since its size
+	// is unknown until then.
+	var payload []byte
+	var packetSize int
+	if c.jsonPayload {
+		if payload, err = marshalJSON(msg); err != nil {
+			atomic.AddUint64(&c.app.stats.MarshalErrors, 1)
+			c.app.recordError(msg)
+			return nil, err
+		}
+		packetSize = len(payload)
+	} else {
+		packetSize = msg.BinarySize()
+	}
 
-	func() {
-		defer func() {
-			if panicErr := recover(); panicErr != nil {
-				err = EncodeError{panicErr}
+	if packetSize > c.maxSendSize {
+		if c.canFragment(packetSize) {
+			if packet, err = c.encodeFragments(msg, packetSize, payload, head); err != nil {
+				atomic.AddUint64(&c.app.stats.MarshalErrors, 1)
+				c.app.recordError(msg)
+				return nil, err
 			}
-		}()
-		msg.MarshalPacket(packet[packetHeadSize:])
+			c.app.recordBytesOut(msg, packetSize)
+			return
+		}
+		atomic.AddUint64(&c.app.stats.SendSizeErrors, 1)
+		c.app.recordError(msg)
+		return nil, SizeError{msg.Identity(), packetSize, c.maxMessageSize()}
+	}
+
+	packet = c.app.Pool.Alloc(c.headSize + packetSize)
+	head.Size, head.ServiceID, head.MessageID = packetSize, msg.ServiceID(), msg.MessageID()
+	c.putHead(packet, head)
+
+	if payload != nil {
+		copy(packet[c.headSize:], payload)
+	} else if err = marshal(msg, packet[c.headSize:]); err != nil {
+		atomic.AddUint64(&c.app.stats.MarshalErrors, 1)
+		c.app.recordError(msg)
+		c.app.Pool.Free(packet)
+		return nil, err
+	}
+	c.record(Outbound, msg.ServiceID(), msg.MessageID(), packet[c.headSize:])
+	c.app.recordBytesOut(msg, packetSize)
+
+	if c.canCompress(packetSize) {
+		if compressed := c.compress(msg, packet[c.headSize:], head); compressed != nil {
+			c.app.Pool.Free(packet)
+			packet = compressed
+		}
+	}
+	return
+}
+
+// putHead encodes the header without sequence, it's set by write() in the
+// order of packets written.
+func (c *codec) putHead(packet []byte, head packetHead) {
+	c.format.encode(packet, &head)
+}
+
+// putSequence numbers the packets in buffer, the buffer has more than one
+// packet when the message is fragmented. It must be called with sendMutex
+// locked.
+func (c *codec) putSequence(buf []byte) {
+	if !c.format.Sequence {
+		return
+	}
+	offset := c.format.sequenceOffset()
+	for len(buf) >= c.headSize {
+		c.sendSeq++
+		c.format.ByteOrder.PutUint32(buf[offset:], c.sendSeq)
+		size := c.headSize + int(c.format.getUint(buf, c.format.LengthSize))
+		if size > len(buf) {
+			break
+		}
+		buf = buf[size:]
+	}
+}
+
+func marshal(msg Message, buf []byte) (err error) {
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			err = MarshalError{msg.Identity(), len(buf), panicErr}
+		}
+	}()
+	msg.MarshalPacket(buf)
+	return
+}
+
+func (c *codec) sendLoop() {
+	defer func() {
+		for _, packet := range c.queue.Clear() {
+			c.app.Pool.Free(packet)
+		}
 	}()
+	for {
+		packet := c.queue.Pop()
+		if packet == nil {
+			select {
+			case <-c.queue.ready:
+				continue
+			case <-c.closeChan:
+				return
+			}
+		}
+
+		var err error
+		if c.app.SendBatchSize > 0 {
+			err = c.sendBatch(packet)
+		} else {
+			err = c.write(packet)
+			c.app.Pool.Free(packet)
+		}
+		if err != nil {
+			c.Close()
+			return
+		}
+	}
+}
+
+func (c *codec) write(packet []byte) (err error) {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
 
 	if c.app.SendTimeout > 0 {
 		c.conn.SetWriteDeadline(time.Now().Add(c.app.SendTimeout))
 		defer c.conn.SetWriteDeadline(time.Time{})
 	}
 
+	c.putSequence(packet)
 	_, err = c.conn.Write(packet)
-	c.app.Pool.Free(packet)
 	return
 }
 
 func (c *codec) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closeChan)
+		if c.release != nil {
+			c.release()
+		}
+	})
 	return c.conn.Close()
 }
 
 type msgFormat struct {
-	newMessage func(byte, byte) (Message, error)
+	app        *App
+	newMessage func(uint16, uint16) (Message, error)
 }
 
-func (f *msgFormat) EncodeMessage(msg interface{}) (buf []byte, err error) {
+func (f *msgFormat) EncodeMessage(msg interface{}) ([]byte, error) {
+	if frame, ok := msg.(encodedFrame); ok {
+		return frame, nil
+	}
 	msg2 := msg.(Message)
-	defer func() {
-		if panicErr := recover(); panicErr != nil {
-			buf = nil
-			err = EncodeError{panicErr}
-		}
-	}()
-	buf = make([]byte, 2+msg2.BinarySize())
-	buf[0] = msg2.ServiceID()
-	buf[1] = msg2.MessageID()
-	msg2.MarshalPacket(buf[2:])
-	return
+	format := &f.app.Header
+	if !format.fits(msg2.ServiceID(), msg2.MessageID()) {
+		return nil, EncodeError{fmt.Sprintf("Message ID Out Of Header Range: '%s' [%d, %d]", msg2.Identity(), msg2.ServiceID(), msg2.MessageID())}
+	}
+	var trace SpanContext
+	if t, ok := msg2.(tracedMessage); ok {
+		msg2, trace = t.Message, t.trace
+	}
+	buf := make([]byte, f.headSize()+msg2.BinarySize())
+	n := format.putIDs(buf, msg2.ServiceID(), msg2.MessageID())
+	if format.Trace {
+		n += copy(buf[n:], trace.TraceID[:])
+		n += copy(buf[n:], trace.SpanID[:])
+	}
+	if err := marshal(msg2, buf[n:]); err != nil {
+		atomic.AddUint64(&f.app.stats.MarshalErrors, 1)
+		return nil, err
+	}
+	return buf, nil
 }
 
 func (f *msgFormat) DecodeMessage(buf []byte) (msg interface{}, err error) {
@@ -166,11 +486,32 @@ func (f *msgFormat) DecodeMessage(buf []byte) (msg interface{}, err error) {
 			err = DecodeError{panicErr}
 		}
 	}()
+	format := &f.app.Header
+	if len(buf) < f.headSize() {
+		return nil, DecodeError{fmt.Sprintf("Too Small Message Size: %d", len(buf))}
+	}
 	var msg2 Message
-	msg2, err = f.newMessage(buf[0], buf[1])
+	msg2, err = f.newMessage(format.getIDs(buf))
 	if err == nil {
-		msg2.UnmarshalPacket(buf[2:])
+		msg2.UnmarshalPacket(buf[f.headSize():])
 		msg = msg2
+		if format.Trace {
+			var trace SpanContext
+			n := copy(trace.TraceID[:], buf[format.idSize():])
+			copy(trace.SpanID[:], buf[format.idSize()+n:])
+			if trace.IsValid() {
+				msg = tracedMessage{msg2, trace}
+			}
+		}
 	}
 	return
 }
+
+// Fastway frames carry the message ids, and the trace fields when the Header
+// has them, the length and other header fields are not used.
+func (f *msgFormat) headSize() int {
+	if f.app.Header.Trace {
+		return f.app.Header.idSize() + traceSize
+	}
+	return f.app.Header.idSize()
+}
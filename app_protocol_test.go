@@ -0,0 +1,57 @@
+package fastapi
+
+import (
+	"testing"
+)
+
+func TestSendSizeError(t *testing.T) {
+	app := newTestApp()
+	app.MaxSendSize = 10
+	session, err := app.Pipe(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	err = Send(session, &testEcho{make([]byte, 11)})
+	if sizeErr, ok := err.(SizeError); !ok || sizeErr.Size != 11 || sizeErr.MaxSize != 10 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if session.IsClosed() {
+		t.Fatal("session closed by SizeError")
+	}
+	testRoundTrip(t, session, make([]byte, 10))
+
+	if _, ok := Send(session, &testBadMessage{size: 5}).(MarshalError); !ok {
+		t.Fatal("expected MarshalError")
+	}
+	testRoundTrip(t, session, []byte("hello"))
+
+	stats := app.Stats()
+	if stats.SendSizeErrors != 1 || stats.MarshalErrors != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestSendOversizedResponse(t *testing.T) {
+	server := newTestApp()
+	server.MaxSendSize = 10
+	listener := ListenPipe()
+	go server.NewServer(listener, nil).Serve()
+	defer listener.Close()
+
+	session, err := newTestApp().DialPipe(listener)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	// The response is dropped by server, but the session is kept.
+	if err := session.Send(&testEcho{make([]byte, 11)}); err != nil {
+		t.Fatal(err)
+	}
+	testRoundTrip(t, session, []byte("hello"))
+	if n := server.Stats().SendSizeErrors; n != 1 {
+		t.Fatalf("SendSizeErrors = %d", n)
+	}
+}
@@ -0,0 +1,118 @@
+package fastapi
+
+import "sync"
+
+// sendQueue is the send queue of codec, packets of higher priority are
+// popped first and packets of the same priority are popped in FIFO order.
+type sendQueue struct {
+	mutex  sync.Mutex
+	levels [numPriorities]packetFIFO
+	size   int
+	max    int
+	ready  chan struct{}
+	space  chan struct{}
+}
+
+func newSendQueue(max int) *sendQueue {
+	return &sendQueue{
+		max:   max,
+		ready: make(chan struct{}, 1),
+		space: make(chan struct{}, 1),
+	}
+}
+
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+func (q *sendQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.size
+}
+
+func (q *sendQueue) Push(level int, packet []byte) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.size >= q.max {
+		return false
+	}
+	q.levels[level].Push(packet)
+	q.size++
+	notify(q.ready)
+	return true
+}
+
+func (q *sendQueue) Pop() []byte {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for i := range q.levels {
+		if packet := q.levels[i].Pop(); packet != nil {
+			q.size--
+			notify(q.space)
+			return packet
+		}
+	}
+	return nil
+}
+
+// DropOldest pops the oldest packet of the lowest priority, but never drops
+// packet has higher priority than the level.
+func (q *sendQueue) DropOldest(level int) []byte {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for i := len(q.levels) - 1; i >= level; i-- {
+		if packet := q.levels[i].Pop(); packet != nil {
+			q.size--
+			return packet
+		}
+	}
+	return nil
+}
+
+func (q *sendQueue) Clear() [][]byte {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	var packets [][]byte
+	for i := range q.levels {
+		for packet := q.levels[i].Pop(); packet != nil; packet = q.levels[i].Pop() {
+			packets = append(packets, packet)
+		}
+	}
+	q.size = 0
+	return packets
+}
+
+type packetFIFO struct {
+	packets [][]byte
+	head    int
+}
+
+func (f *packetFIFO) Push(packet []byte) {
+	if f.head > 32 && f.head*2 > len(f.packets) {
+		n := copy(f.packets, f.packets[f.head:])
+		for i := n; i < len(f.packets); i++ {
+			f.packets[i] = nil
+		}
+		f.packets = f.packets[:n]
+		f.head = 0
+	}
+	f.packets = append(f.packets, packet)
+}
+
+func (f *packetFIFO) Pop() []byte {
+	if f.head == len(f.packets) {
+		return nil
+	}
+	packet := f.packets[f.head]
+	f.packets[f.head] = nil
+	f.head++
+	if f.head == len(f.packets) {
+		f.packets = f.packets[:0]
+		f.head = 0
+	}
+	return packet
+}
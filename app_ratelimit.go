@@ -0,0 +1,145 @@
+package fastapi
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/funny/link"
+)
+
+type RateLimitAction int
+
+const (
+	// RateLimitDrop drops the request silently.
+	RateLimitDrop RateLimitAction = iota
+
+	// RateLimitReply sends the message created by RateLimit.Reply instead
+	// of handling the request.
+	RateLimitReply
+
+	// RateLimitDisconnect closes the session.
+	RateLimitDisconnect
+)
+
+// RateLimit is a token bucket allows Rate requests per second on average and
+// Burst requests at most in a moment, Burst less than 1 is taken as 1.
+type RateLimit struct {
+	Rate   float64
+	Burst  int
+	Action RateLimitAction
+	Reply  func(req Message) Message
+}
+
+// SetRateLimit limits the requests of the message per session, it must be
+// called before serving.
+func (app *App) SetRateLimit(serviceID, messageID uint16, limit RateLimit) {
+	if app.rateLimits == nil {
+		app.rateLimits = make(map[uint32]*RateLimit)
+	}
+	app.rateLimits[uint32(serviceID)<<16|uint32(messageID)] = &limit
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (limit *RateLimit) burst() float64 {
+	if limit.Burst < 1 {
+		return 1
+	}
+	return float64(limit.Burst)
+}
+
+// Allow refills the bucket and reports whether it has a token, the token is
+// not taken.
+func (b *tokenBucket) Allow(limit *RateLimit, now time.Time) bool {
+	burst := limit.burst()
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * limit.Rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+	return b.tokens >= 1
+}
+
+// Take takes a token when the bucket has one.
+func (b *tokenBucket) Take(limit *RateLimit, now time.Time) bool {
+	if !b.Allow(limit, now) {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateLimiter holds the buckets of one session, it's only used by the
+// goroutine of handleSessoin so there's no lock.
+type rateLimiter struct {
+	app      *App
+	session  tokenBucket
+	messages map[uint32]*tokenBucket
+}
+
+func (app *App) newRateLimiter() *rateLimiter {
+	if app.SessionRateLimit == nil && len(app.rateLimits) == 0 {
+		return nil
+	}
+	return &rateLimiter{
+		app:      app,
+		messages: make(map[uint32]*tokenBucket),
+	}
+}
+
+// Check returns the exceeded limit, or nil when the request is allowed. The
+// tokens are only taken when both the message and the session limits allow
+// the request.
+func (l *rateLimiter) Check(req Message) *RateLimit {
+	now := time.Now()
+
+	var bucket *tokenBucket
+	key := uint32(req.ServiceID())<<16 | uint32(req.MessageID())
+	if limit, exists := l.app.rateLimits[key]; exists {
+		if bucket, exists = l.messages[key]; !exists {
+			bucket = &tokenBucket{}
+			l.messages[key] = bucket
+		}
+		if !bucket.Allow(limit, now) {
+			return limit
+		}
+	}
+
+	if limit := l.app.SessionRateLimit; limit != nil {
+		if !l.session.Allow(limit, now) {
+			return limit
+		}
+		l.session.tokens--
+	}
+	if bucket != nil {
+		bucket.tokens--
+	}
+	return nil
+}
+
+// rateLimited applies the action of limit, returns false if the session
+// should be closed.
+func (app *App) rateLimited(session *link.Session, req Message, limit *RateLimit) bool {
+	atomic.AddUint64(&app.stats.RateLimited, 1)
+
+	switch limit.Action {
+	case RateLimitReply:
+		if limit.Reply != nil {
+			if rsp := limit.Reply(req); rsp != nil {
+				Send(session, rsp)
+				return !session.IsClosed()
+			}
+		}
+	case RateLimitDisconnect:
+		atomic.AddUint64(&app.stats.RateLimitKicked, 1)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package fastapi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	for _, limit := range []*RateLimit{
+		{Rate: 100},
+		{Rate: 100, Burst: 1},
+	} {
+		var b tokenBucket
+		if !b.Allow(limit, now) {
+			t.Fatalf("%+v: first request rejected", limit)
+		}
+		b.tokens--
+		if b.Allow(limit, now) {
+			t.Fatalf("%+v: burst exceeded", limit)
+		}
+		if !b.Allow(limit, now.Add(10*time.Millisecond)) {
+			t.Fatalf("%+v: token not refilled", limit)
+		}
+	}
+}
+
+func TestRateLimitCheck(t *testing.T) {
+	app := newTestApp()
+	app.SetRateLimit(1, 1, RateLimit{Rate: 0.001, Burst: 3})
+	app.SessionRateLimit = &RateLimit{Rate: 0.001, Burst: 2}
+	limiter := app.newRateLimiter()
+	req := &testEcho{}
+
+	for i := 0; i < 2; i++ {
+		if limit := limiter.Check(req); limit != nil {
+			t.Fatalf("request #%d rejected", i)
+		}
+	}
+	if limit := limiter.Check(req); limit != app.SessionRateLimit {
+		t.Fatalf("expected session limit, got %+v", limit)
+	}
+
+	// The rejected request didn't spend the token of message.
+	bucket := limiter.messages[1<<16|1]
+	if bucket.tokens < 1 {
+		t.Fatalf("message tokens %f spent by rejected request", bucket.tokens)
+	}
+}
+
+func TestRateLimitReply(t *testing.T) {
+	app := newTestApp()
+	app.MaxSendSize = 10
+	app.SetRateLimit(1, 1, RateLimit{
+		Rate:   0.001,
+		Action: RateLimitReply,
+		Reply: func(req Message) Message {
+			if string(req.(*testEcho).Data) == "big" {
+				return &testEcho{make([]byte, 11)}
+			}
+			return &testEcho{[]byte("slow")}
+		},
+	})
+	session, err := app.Pipe(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	testRoundTrip(t, session, []byte("hello"))
+
+	// The reply can't be sent, but the session is kept.
+	if err := session.Send(&testEcho{[]byte("big")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Send(&testEcho{[]byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+	rsp, err := session.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := string(rsp.(*testEcho).Data); data != "slow" {
+		t.Fatalf("unexpected reply %q", data)
+	}
+}
@@ -0,0 +1,257 @@
+package fastapi
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+)
+
+// Capture file: "FCAP" + version(1) + records, each record is
+//
+//	timestamp(8) + side(1) + direction(1) + session id(8) + service id(2) +
+//	message id(2) + payload size(4) + payload
+//
+// All integers are little endian, the timestamp is unix nanoseconds.
+const (
+	captureMagic      = "FCAP"
+	captureVersion    = 2
+	captureFileHead   = 4 + 1
+	captureRecordHead = 8 + 1 + 1 + 8 + 2 + 2 + 4
+)
+
+// Side is the role of the recorded session, the requests are inbound packets
+// of the server sessions and outbound packets of the client sessions.
+type Side byte
+
+const (
+	ClientSide Side = iota
+	ServerSide
+)
+
+func (s Side) String() string {
+	if s == ClientSide {
+		return "client"
+	}
+	return "server"
+}
+
+type Direction byte
+
+const (
+	Inbound Direction = iota
+	Outbound
+)
+
+func (d Direction) String() string {
+	if d == Inbound {
+		return "in"
+	}
+	return "out"
+}
+
+type CaptureRecord struct {
+	Time      time.Time
+	Side      Side
+	Direction Direction
+	SessionID uint64
+	ServiceID uint16
+	MessageID uint16
+	Payload   []byte
+}
+
+type CaptureError struct {
+	Message interface{}
+}
+
+func (captureError CaptureError) Error() string {
+	return fmt.Sprintf("Capture Error: %v", captureError.Message)
+}
+
+// Recorder writes the packets into capture file. The file is rotated when it
+// exceeds MaxFileSize, the rotated files are named path.1, path.2 ... and the
+// ones beyond MaxFiles are removed. Zero MaxFileSize disables rotation.
+type Recorder struct {
+	path        string
+	maxFileSize int64
+	maxFiles    int
+	mutex       sync.Mutex
+	file        *os.File
+	writer      *bufio.Writer
+	size        int64
+	head        [captureRecordHead]byte
+	err         error
+}
+
+func NewRecorder(path string, maxFileSize int64, maxFiles int) (*Recorder, error) {
+	r := &Recorder{
+		path:        path,
+		maxFileSize: maxFileSize,
+		maxFiles:    maxFiles,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Recorder) open() error {
+	file, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+	r.file = file
+	r.writer = bufio.NewWriter(file)
+	r.writer.WriteString(captureMagic)
+	r.writer.WriteByte(captureVersion)
+	r.size = captureFileHead
+	return nil
+}
+
+func (r *Recorder) rotate() error {
+	if err := r.closeFile(); err != nil {
+		return err
+	}
+	if r.maxFiles > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxFiles))
+		for i := r.maxFiles - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return err
+		}
+	}
+	return r.open()
+}
+
+func (r *Recorder) closeFile() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.writer.Flush()
+	if err2 := r.file.Close(); err == nil {
+		err = err2
+	}
+	r.file = nil
+	return err
+}
+
+// Record writes one packet, the error of previous writing is returned and the
+// recorder stops working after that.
+func (r *Recorder) Record(side Side, direction Direction, sessionID uint64, serviceID, messageID uint16, payload []byte) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	if r.file == nil {
+		return CaptureError{"Recorder Closed"}
+	}
+
+	size := int64(captureRecordHead + len(payload))
+	if r.maxFileSize > 0 && r.size > captureFileHead && r.size+size > r.maxFileSize {
+		if r.err = r.rotate(); r.err != nil {
+			return r.err
+		}
+	}
+
+	head := r.head[:]
+	binary.LittleEndian.PutUint64(head, uint64(time.Now().UnixNano()))
+	head[8] = byte(side)
+	head[9] = byte(direction)
+	binary.LittleEndian.PutUint64(head[10:], sessionID)
+	binary.LittleEndian.PutUint16(head[18:], serviceID)
+	binary.LittleEndian.PutUint16(head[20:], messageID)
+	binary.LittleEndian.PutUint32(head[22:], uint32(len(payload)))
+	if _, r.err = r.writer.Write(head); r.err == nil {
+		_, r.err = r.writer.Write(payload)
+	}
+	r.size += size
+	return r.err
+}
+
+func (r *Recorder) Flush() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	return r.writer.Flush()
+}
+
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.closeFile()
+}
+
+// CaptureReader reads the records of capture file in order.
+type CaptureReader struct {
+	reader *bufio.Reader
+	head   [captureRecordHead]byte
+}
+
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	reader := bufio.NewReader(r)
+	var head [captureFileHead]byte
+	if _, err := io.ReadFull(reader, head[:]); err != nil {
+		return nil, err
+	}
+	if string(head[:4]) != captureMagic {
+		return nil, CaptureError{"Bad Magic"}
+	}
+	if head[4] != captureVersion {
+		return nil, CaptureError{fmt.Sprintf("Unsupported Version: %d", head[4])}
+	}
+	return &CaptureReader{reader: reader}, nil
+}
+
+// Next returns io.EOF when there's no more record.
+func (r *CaptureReader) Next() (*CaptureRecord, error) {
+	head := r.head[:]
+	if _, err := io.ReadFull(r.reader, head); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = CaptureError{"Truncated Record"}
+		}
+		return nil, err
+	}
+	record := &CaptureRecord{
+		Time:      time.Unix(0, int64(binary.LittleEndian.Uint64(head))),
+		Side:      Side(head[8]),
+		Direction: Direction(head[9]),
+		SessionID: binary.LittleEndian.Uint64(head[10:]),
+		ServiceID: binary.LittleEndian.Uint16(head[18:]),
+		MessageID: binary.LittleEndian.Uint16(head[20:]),
+		Payload:   make([]byte, binary.LittleEndian.Uint32(head[22:])),
+	}
+	if _, err := io.ReadFull(r.reader, record.Payload); err != nil {
+		return nil, CaptureError{"Truncated Record"}
+	}
+	return record, nil
+}
+
+// SetRecorder replaces the App.Recorder for the session, set nil to stop
+// recording the session.
+func SetRecorder(session *link.Session, r *Recorder) {
+	if c, ok := session.Codec().(*codec); ok {
+		c.recorder.Store(r)
+	}
+}
+
+func (c *codec) record(direction Direction, serviceID, messageID uint16, payload []byte) {
+	r := c.recorder.Load()
+	if r == nil {
+		return
+	}
+	var sessionID uint64
+	if c.session != nil {
+		sessionID = c.session.ID()
+	}
+	r.Record(c.side, direction, sessionID, serviceID, messageID, payload)
+}
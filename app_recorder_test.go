@@ -0,0 +1,94 @@
+package fastapi
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readCapture(t *testing.T, path string) []*CaptureRecord {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, err := NewCaptureReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []*CaptureRecord
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+}
+
+func TestRecorderClient(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client.cap")
+	recorder, err := NewRecorder(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newTestApp()
+	listener := ListenPipe()
+	go server.NewServer(listener, nil).Serve()
+	defer listener.Close()
+
+	client := newTestApp()
+	client.Recorder = recorder
+	session, err := client.DialPipe(listener)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRoundTrip(t, session, []byte("hello"))
+	session.Close()
+	recorder.Close()
+
+	records := readCapture(t, path)
+	if len(records) != 2 || records[0].Direction != Outbound || records[1].Direction != Inbound {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	for _, record := range records {
+		if record.Side != ClientSide {
+			t.Fatalf("unexpected side: %+v", record)
+		}
+	}
+}
+
+func TestRecorderPipe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pipe.cap")
+	recorder, err := NewRecorder(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := newTestApp()
+	app.Recorder = recorder
+	session, err := app.Pipe(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRoundTrip(t, session, []byte("hello"))
+	session.Close()
+	recorder.Close()
+
+	// Only the server session is recorded.
+	records := readCapture(t, path)
+	if len(records) != 2 || records[0].Direction != Inbound || records[1].Direction != Outbound {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	for _, record := range records {
+		if record.Side != ServerSide {
+			t.Fatalf("unexpected side: %+v", record)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package fastapi
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+)
+
+// RawMessage carries the payload without decoding, it's used by the replay
+// to send the recorded packets and to receive the responses.
+type RawMessage struct {
+	Service uint16
+	Message uint16
+	Payload []byte
+}
+
+func (m *RawMessage) ServiceID() uint16 { return m.Service }
+
+func (m *RawMessage) MessageID() uint16 { return m.Message }
+
+func (m *RawMessage) Identity() string { return fmt.Sprintf("raw[%d, %d]", m.Service, m.Message) }
+
+func (m *RawMessage) BinarySize() int { return len(m.Payload) }
+
+func (m *RawMessage) MarshalPacket(p []byte) { copy(p, m.Payload) }
+
+func (m *RawMessage) UnmarshalPacket(p []byte) { m.Payload = append([]byte(nil), p...) }
+
+func newRawMessage(serviceID, messageID uint16) (Message, error) {
+	return &RawMessage{Service: serviceID, Message: messageID}, nil
+}
+
+// NewRawClient creates client session receives RawMessage.
+func (app *App) NewRawClient(conn net.Conn) (*link.Session, error) {
+	c, err := app.newClientCodecWith(conn, newRawMessage)
+	if err != nil {
+		return nil, err
+	}
+	return bindSession(link.NewSession(c, 0)), nil
+}
+
+// PipeDialer returns the dialer for Replayer that serves every connection by
+// the App in memory, like App.Pipe().
+func (app *App) PipeDialer(handler Handler, opts ...CodecOption) func() (net.Conn, error) {
+	if handler == nil {
+		handler = &noHandler{}
+	}
+	return func() (net.Conn, error) {
+		serverConn, clientConn := Pipe()
+		go func() {
+			codec, err := app.newServerCodec(serverConn, opts...)
+			if err != nil {
+				return
+			}
+			app.handleSessoin(link.NewSession(codec, 0), handler)
+		}()
+		return clientConn, nil
+	}
+}
+
+// DefaultReplayTimeout is used when Replayer.Timeout is zero.
+const DefaultReplayTimeout = 5 * time.Second
+
+// Replayer sends the requests of capture file to the server, one connection
+// for each recorded session, and compares the responses with the recorded
+// ones of the session in order. The requests are the inbound packets of the
+// server captures and the outbound packets of the client captures.
+type Replayer struct {
+	// App provides the header format and the handshake of client.
+	App  *App
+	Dial func() (net.Conn, error)
+
+	// Speed scales the recorded intervals, 1 is the original timing, 2 is
+	// twice as fast, zero sends as fast as possible.
+	Speed float64
+
+	// Timeout is the time to wait for the remaining responses after all the
+	// requests were sent, DefaultReplayTimeout when it's zero.
+	Timeout time.Duration
+
+	// Compare returns true when the actual response matches the recorded one,
+	// the default compares the message ids and the payload.
+	Compare func(expected, actual *CaptureRecord) bool
+}
+
+type ReplayError struct {
+	Message interface{}
+}
+
+func (replayError ReplayError) Error() string {
+	return fmt.Sprintf("Replay Error: %v", replayError.Message)
+}
+
+type ReplayMismatch struct {
+	SessionID uint64
+	Index     int
+	Expected  *CaptureRecord
+	Actual    *CaptureRecord
+}
+
+func (m ReplayMismatch) String() string {
+	describe := func(r *CaptureRecord) string {
+		if r == nil {
+			return "none"
+		}
+		return fmt.Sprintf("[%d, %d] %d bytes", r.ServiceID, r.MessageID, len(r.Payload))
+	}
+	return fmt.Sprintf("session %d response #%d: expected %s, actual %s", m.SessionID, m.Index, describe(m.Expected), describe(m.Actual))
+}
+
+type ReplayResult struct {
+	Sessions   int
+	Requests   int
+	Responses  int
+	Matched    int
+	Mismatches []ReplayMismatch
+}
+
+type replaySession struct {
+	session  *link.Session
+	side     Side
+	expected []*CaptureRecord
+	mutex    sync.Mutex
+	actual   []*CaptureRecord
+	recv     chan struct{}
+	done     chan struct{}
+}
+
+func (s *replaySession) recvLoop(sessionID uint64) {
+	defer close(s.done)
+	for {
+		msg, err := s.session.Receive()
+		if err != nil {
+			return
+		}
+		raw := msg.(*RawMessage)
+		s.mutex.Lock()
+		s.actual = append(s.actual, &CaptureRecord{
+			Time:      time.Now(),
+			Side:      s.side,
+			Direction: responseDirection(s.side),
+			SessionID: sessionID,
+			ServiceID: raw.Service,
+			MessageID: raw.Message,
+			Payload:   raw.Payload,
+		})
+		s.mutex.Unlock()
+		select {
+		case s.recv <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *replaySession) received() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.actual)
+}
+
+func (r *Replayer) Replay(reader *CaptureReader) (*ReplayResult, error) {
+	if r.App == nil {
+		return nil, ReplayError{"App Is Nil"}
+	}
+	if r.Dial == nil {
+		return nil, ReplayError{"Dial Is Nil"}
+	}
+
+	sessions := make(map[uint64]*replaySession)
+	var order []uint64
+	result := &ReplayResult{}
+
+	defer func() {
+		for _, s := range sessions {
+			s.session.Close()
+		}
+	}()
+
+	var first time.Time
+	start := time.Now()
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		s := sessions[record.SessionID]
+		if s == nil {
+			conn, err := r.Dial()
+			if err != nil {
+				return nil, err
+			}
+			session, err := r.App.NewRawClient(conn)
+			if err != nil {
+				return nil, err
+			}
+			s = &replaySession{
+				session: session,
+				side:    record.Side,
+				recv:    make(chan struct{}, 1),
+				done:    make(chan struct{}),
+			}
+			sessions[record.SessionID] = s
+			order = append(order, record.SessionID)
+			go s.recvLoop(record.SessionID)
+		}
+
+		if record.Direction == responseDirection(record.Side) {
+			s.expected = append(s.expected, record)
+			continue
+		}
+
+		if first.IsZero() {
+			first = record.Time
+		}
+		if r.Speed > 0 {
+			offset := time.Duration(float64(record.Time.Sub(first)) / r.Speed)
+			if d := time.Until(start.Add(offset)); d > 0 {
+				time.Sleep(d)
+			}
+		}
+		msg := &RawMessage{record.ServiceID, record.MessageID, record.Payload}
+		if err := s.session.Send(msg); err != nil {
+			return nil, err
+		}
+		result.Requests++
+	}
+
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultReplayTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+wait:
+	for _, id := range order {
+		s := sessions[id]
+		for s.received() < len(s.expected) {
+			select {
+			case <-s.recv:
+			case <-s.done:
+				continue wait
+			case <-timer.C:
+				break wait
+			}
+		}
+	}
+
+	compare := r.Compare
+	if compare == nil {
+		compare = compareRecord
+	}
+	result.Sessions = len(sessions)
+	for _, id := range order {
+		s := sessions[id]
+		s.mutex.Lock()
+		actual := s.actual
+		s.mutex.Unlock()
+		result.Responses += len(actual)
+
+		n := len(s.expected)
+		if len(actual) > n {
+			n = len(actual)
+		}
+		for i := 0; i < n; i++ {
+			var expected, got *CaptureRecord
+			if i < len(s.expected) {
+				expected = s.expected[i]
+			}
+			if i < len(actual) {
+				got = actual[i]
+			}
+			if expected != nil && got != nil && compare(expected, got) {
+				result.Matched++
+				continue
+			}
+			result.Mismatches = append(result.Mismatches, ReplayMismatch{id, i, expected, got})
+		}
+	}
+	return result, nil
+}
+
+// responseDirection returns the direction of the responses recorded by the
+// session of the side.
+func responseDirection(side Side) Direction {
+	if side == ServerSide {
+		return Outbound
+	}
+	return Inbound
+}
+
+func compareRecord(expected, actual *CaptureRecord) bool {
+	return expected.ServiceID == actual.ServiceID &&
+		expected.MessageID == actual.MessageID &&
+		bytes.Equal(expected.Payload, actual.Payload)
+}
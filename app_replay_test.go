@@ -0,0 +1,176 @@
+package fastapi
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+)
+
+// testDoubleService responds the data repeated twice, the responses don't
+// match the requests, so the replay has to tell them apart.
+type testDoubleService struct{}
+
+func (s *testDoubleService) APIs() APIs {
+	return APIs{1: {testDoubleReq{}, testDoubleRsp{}}}
+}
+
+func (s *testDoubleService) ServiceID() uint16 {
+	return 5
+}
+
+func (s *testDoubleService) NewRequest(id uint16) Message {
+	if id == 1 {
+		return &testDoubleReq{}
+	}
+	return nil
+}
+
+func (s *testDoubleService) NewResponse(id uint16) Message {
+	if id == 1 {
+		return &testDoubleRsp{}
+	}
+	return nil
+}
+
+func (s *testDoubleService) HandleRequest(session *link.Session, req Message) {
+	data := req.(*testDoubleReq).Data
+	Send(session, &testDoubleRsp{testEcho{append(data, data...)}})
+}
+
+type testDoubleReq struct{ testEcho }
+type testDoubleRsp struct{ testEcho }
+
+func (m *testDoubleReq) ServiceID() uint16 { return 5 }
+func (m *testDoubleRsp) ServiceID() uint16 { return 5 }
+
+func (m *testDoubleReq) Identity() string { return "testDoubleService.testDoubleReq" }
+func (m *testDoubleRsp) Identity() string { return "testDoubleService.testDoubleRsp" }
+
+func newDoubleApp() *App {
+	app := New()
+	app.Register(5, &testDoubleService{})
+	return app
+}
+
+func testReplay(t *testing.T, path string) *ReplayResult {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, err := NewCaptureReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayer := &Replayer{App: New(), Dial: newDoubleApp().PipeDialer(nil)}
+	result, err := replayer.Replay(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func testDoubleCapture(t *testing.T, server, client *App) {
+	t.Helper()
+	conn, err := server.PipeDialer(nil)()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := client.Connect(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{"ab", "abc", "abcd"} {
+		if err := session.Send(&testDoubleReq{testEcho{[]byte(data)}}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := session.Receive(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	session.Close()
+}
+
+func TestReplaySides(t *testing.T) {
+	for _, side := range []Side{ServerSide, ClientSide} {
+		path := filepath.Join(t.TempDir(), side.String()+".cap")
+		recorder, err := NewRecorder(path, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server, client := newDoubleApp(), newDoubleApp()
+		if side == ServerSide {
+			server.Recorder = recorder
+		} else {
+			client.Recorder = recorder
+		}
+		testDoubleCapture(t, server, client)
+		// The server session may still be writing the capture.
+		for i := 0; i < 100 && recorder.Flush() == nil && len(readCapture(t, path)) < 6; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		recorder.Close()
+
+		result := testReplay(t, path)
+		if result.Sessions != 1 || result.Requests != 3 || result.Responses != 3 || result.Matched != 3 || len(result.Mismatches) != 0 {
+			t.Fatalf("%s: unexpected result: %+v", side, result)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay.cap")
+	recorder, err := NewRecorder(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := newTestApp()
+	app.Recorder = recorder
+	session, err := app.Pipe(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{"1", "22", "333"} {
+		testRoundTrip(t, session, []byte(data))
+	}
+	session.Close()
+	recorder.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, err := NewCaptureReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Zero Timeout waits for the responses too.
+	server := newTestApp()
+	replayer := &Replayer{App: New(), Dial: server.PipeDialer(nil)}
+	result, err := replayer.Replay(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Sessions != 1 || result.Requests != 3 || result.Responses != 3 || result.Matched != 3 || len(result.Mismatches) != 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
+
+func TestReplayError(t *testing.T) {
+	for _, replayer := range []*Replayer{
+		{Dial: New().PipeDialer(nil)},
+		{App: New()},
+	} {
+		if _, err := replayer.Replay(nil); err == nil {
+			t.Fatalf("%+v: expected error", replayer)
+		} else if _, ok := err.(ReplayError); !ok {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package fastapi
+
+import "github.com/funny/link"
+
+// NoCopyUnmarshaler is implemented by messages that can reference byte slice
+// fields to the packet instead of copying them. The packet is only valid
+// until it's released, see App.RetainRecvPacket.
+type NoCopyUnmarshaler interface {
+	UnmarshalPacketNoCopy([]byte)
+}
+
+func (c *codec) freePacket(msg Message, packet []byte) {
+	if _, ok := msg.(NoCopyUnmarshaler); ok && c.app.RetainRecvPacket && !c.jsonPayload {
+		c.retained = packet
+		return
+	}
+	c.app.Pool.Free(packet)
+}
+
+func (c *codec) releasePacket() {
+	if c.retained != nil {
+		c.app.Pool.Free(c.retained)
+		c.retained = nil
+	}
+}
+
+// takePacket moves the packet referenced by the last received message out of
+// codec, the next Receive() doesn't free it.
+func takePacket(session *link.Session) []byte {
+	if c, ok := session.Codec().(*codec); ok {
+		packet := c.retained
+		c.retained = nil
+		return packet
+	}
+	return nil
+}
+
+func (app *App) freePacket(packet []byte) {
+	if packet != nil {
+		app.Pool.Free(packet)
+	}
+}
+
+// ReleasePacket frees the packet referenced by the last received message, the
+// message must not be used after that. It's called by App after the request
+// handled, clients can call it when the response is processed.
+func ReleasePacket(session *link.Session) {
+	if c, ok := session.Codec().(*codec); ok {
+		c.releasePacket()
+	}
+}
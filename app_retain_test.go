@@ -0,0 +1,110 @@
+package fastapi
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/funny/link"
+)
+
+type testNoCopyService struct{}
+
+func (s *testNoCopyService) APIs() APIs {
+	return APIs{1: {testNoCopyEcho{}, testNoCopyEcho{}}}
+}
+
+func (s *testNoCopyService) ServiceID() uint16 {
+	return 2
+}
+
+func (s *testNoCopyService) NewRequest(id uint16) Message {
+	return &testNoCopyEcho{}
+}
+
+func (s *testNoCopyService) NewResponse(id uint16) Message {
+	return &testNoCopyEcho{}
+}
+
+func (s *testNoCopyService) HandleRequest(session *link.Session, req Message) {
+	Send(session, req)
+}
+
+type testNoCopyEcho struct {
+	testEcho
+}
+
+func (m *testNoCopyEcho) ServiceID() uint16              { return 2 }
+func (m *testNoCopyEcho) Identity() string               { return "testNoCopyService.testNoCopyEcho" }
+func (m *testNoCopyEcho) UnmarshalPacketNoCopy(p []byte) { m.Data = p }
+
+// poisonPool overwrites the freed buffers, so the message references a freed
+// packet reads garbage.
+type poisonPool struct{}
+
+func (p *poisonPool) Alloc(size int) []byte {
+	return make([]byte, size)
+}
+
+func (p *poisonPool) Free(buf []byte) {
+	for i := range buf {
+		buf[i] = 0xFF
+	}
+}
+
+// asyncHandler runs the works in another goroutine, like the handlers queue
+// requests to the goroutine of player.
+type asyncHandler struct {
+	works chan func()
+}
+
+func (h *asyncHandler) InitSession(session *link.Session) error {
+	return nil
+}
+
+func (h *asyncHandler) Transaction(session *link.Session, req Message, work func()) {
+	h.works <- work
+}
+
+func TestRetainRecvPacketAsync(t *testing.T) {
+	app := New()
+	app.Register(2, &testNoCopyService{})
+	app.Pool = &poisonPool{}
+	app.RetainRecvPacket = true
+
+	handler := &asyncHandler{make(chan func(), 10)}
+	session, err := app.Pipe(handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	const n = 5
+	for i := 0; i < n; i++ {
+		if err := session.Send(&testNoCopyEcho{testEcho{[]byte(fmt.Sprint("message ", i))}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Run the works after all the requests received, the packets of them
+	// were freed by the next Receive() if they're not kept for the works.
+	works := make([]func(), n)
+	for i := range works {
+		works[i] = <-handler.works
+	}
+	for _, work := range works {
+		work()
+	}
+
+	for i := 0; i < n; i++ {
+		rsp, err := session.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := rsp.(*testNoCopyEcho).Data
+		if expected := []byte(fmt.Sprint("message ", i)); !bytes.Equal(data, expected) {
+			t.Fatalf("response %q, expected %q", data, expected)
+		}
+		ReleasePacket(session)
+	}
+}
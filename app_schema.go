@@ -0,0 +1,247 @@
+package fastapi
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Schema describes the services, messages and wire format of an App, it's
+// exported in JSON for the tools like the CLI client, which encode and decode
+// messages without the Go types.
+type Schema struct {
+	Hash      string          `json:"hash"`
+	Header    SchemaHeader    `json:"header"`
+	Handshake bool            `json:"handshake"`
+	Features  Feature         `json:"features"`
+	Services  []SchemaService `json:"services"`
+}
+
+type SchemaHeader struct {
+	LengthSize    int    `json:"length_size"`
+	ByteOrder     string `json:"byte_order"`
+	ServiceIDSize int    `json:"service_id_size"`
+	MessageIDSize int    `json:"message_id_size"`
+	Flags         bool   `json:"flags,omitempty"`
+	Sequence      bool   `json:"sequence,omitempty"`
+	Trace         bool   `json:"trace,omitempty"`
+}
+
+type SchemaService struct {
+	ID        uint16          `json:"id"`
+	Name      string          `json:"name"`
+	Requests  []SchemaMessage `json:"requests"`
+	Responses []SchemaMessage `json:"responses"`
+}
+
+type SchemaMessage struct {
+	ID   uint16      `json:"id"`
+	Name string      `json:"name"`
+	Type *SchemaType `json:"type"`
+}
+
+// SchemaType is the fastbin layout of a Go type. Kind is the reflect.Kind
+// name, Elem is set for ptr, slice, array and map, Key is set for map, Len is
+// set for array and Fields is set for struct.
+type SchemaType struct {
+	Kind   string        `json:"kind"`
+	Name   string        `json:"name,omitempty"`
+	Elem   *SchemaType   `json:"elem,omitempty"`
+	Key    *SchemaType   `json:"key,omitempty"`
+	Len    int           `json:"len,omitempty"`
+	Fields []SchemaField `json:"fields,omitempty"`
+}
+
+type SchemaField struct {
+	Name string      `json:"name"`
+	Type *SchemaType `json:"type"`
+}
+
+type SchemaError struct {
+	Message interface{}
+}
+
+func (schemaError SchemaError) Error() string {
+	return fmt.Sprintf("Schema Error: %v", schemaError.Message)
+}
+
+func (app *App) Schema() (*Schema, error) {
+	byteOrder := "little"
+	if app.Header.ByteOrder == binary.BigEndian {
+		byteOrder = "big"
+	}
+	schema := &Schema{
+		Hash: fmt.Sprintf("%016x", app.schemaHashOrDefault()),
+		Header: SchemaHeader{
+			LengthSize:    app.Header.LengthSize,
+			ByteOrder:     byteOrder,
+			ServiceIDSize: app.Header.ServiceIDSize,
+			MessageIDSize: app.Header.MessageIDSize,
+			Flags:         app.Header.Flags,
+			Sequence:      app.Header.Sequence,
+			Trace:         app.Header.Trace,
+		},
+		Handshake: app.Handshake,
+		Features:  app.Features,
+		Services:  []SchemaService{},
+	}
+	for _, serviceType := range app.serviceTypes {
+		service := SchemaService{
+			ID:   serviceType.id,
+			Name: serviceType.Name(),
+		}
+		var err error
+		if service.Requests, err = schemaMessages(serviceType.requests); err != nil {
+			return nil, err
+		}
+		if service.Responses, err = schemaMessages(serviceType.responses); err != nil {
+			return nil, err
+		}
+		schema.Services = append(schema.Services, service)
+	}
+	sort.Slice(schema.Services, func(i, j int) bool {
+		return schema.Services[i].ID < schema.Services[j].ID
+	})
+	return schema, nil
+}
+
+func (app *App) ExportSchema(w io.Writer) error {
+	schema, err := app.Schema()
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(schema)
+}
+
+func LoadSchema(r io.Reader) (*Schema, error) {
+	var schema Schema
+	if err := json.NewDecoder(r).Decode(&schema); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+func schemaMessages(types []*MessageType) ([]SchemaMessage, error) {
+	messages := []SchemaMessage{}
+	for _, t := range types {
+		st, err := schemaType(t.t, nil)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, SchemaMessage{t.id, t.Name(), st})
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].ID < messages[j].ID
+	})
+	return messages, nil
+}
+
+func schemaType(t reflect.Type, visiting []reflect.Type) (*SchemaType, error) {
+	st := &SchemaType{Kind: t.Kind().String()}
+	var err error
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice:
+		st.Elem, err = schemaType(t.Elem(), visiting)
+	case reflect.Array:
+		st.Len = t.Len()
+		st.Elem, err = schemaType(t.Elem(), visiting)
+	case reflect.Map:
+		if st.Key, err = schemaType(t.Key(), visiting); err == nil {
+			st.Elem, err = schemaType(t.Elem(), visiting)
+		}
+	case reflect.Struct:
+		for _, v := range visiting {
+			if v == t {
+				return nil, SchemaError{fmt.Sprintf("Recursive Type: %s", t)}
+			}
+		}
+		visiting = append(visiting, t)
+		st.Name = t.Name()
+		st.Fields = []SchemaField{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			var ft *SchemaType
+			if ft, err = schemaType(field.Type, visiting); err != nil {
+				return nil, err
+			}
+			st.Fields = append(st.Fields, SchemaField{field.Name, ft})
+		}
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+	default:
+		err = SchemaError{fmt.Sprintf("Unsupported Type: %s", t)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// NewApp creates an App has the header format and the handshake described by
+// the schema, it's used with NewRawClient() to talk to the server exported
+// the schema.
+func (schema *Schema) NewApp() (*App, error) {
+	app := New()
+	app.Header = HeaderFormat{
+		LengthSize:    schema.Header.LengthSize,
+		ByteOrder:     binary.LittleEndian,
+		ServiceIDSize: schema.Header.ServiceIDSize,
+		MessageIDSize: schema.Header.MessageIDSize,
+		Flags:         schema.Header.Flags,
+		Sequence:      schema.Header.Sequence,
+		Trace:         schema.Header.Trace,
+	}
+	if schema.Header.ByteOrder == "big" {
+		app.Header.ByteOrder = binary.BigEndian
+	}
+	if err := app.Header.validate(); err != nil {
+		return nil, err
+	}
+	hash, err := strconv.ParseUint(schema.Hash, 16, 64)
+	if err != nil {
+		return nil, SchemaError{fmt.Sprintf("Bad Hash: %q", schema.Hash)}
+	}
+	app.schemaHash = hash
+	app.Handshake = schema.Handshake
+	app.Features = schema.Features
+	return app, nil
+}
+
+// Request finds the request message by "Service.Message".
+func (schema *Schema) Request(name string) (*SchemaService, *SchemaMessage) {
+	for i := range schema.Services {
+		service := &schema.Services[i]
+		for j := range service.Requests {
+			if service.Name+"."+service.Requests[j].Name == name {
+				return service, &service.Requests[j]
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (schema *Schema) Response(serviceID, messageID uint16) (*SchemaService, *SchemaMessage) {
+	for i := range schema.Services {
+		service := &schema.Services[i]
+		if service.ID != serviceID {
+			continue
+		}
+		for j := range service.Responses {
+			if service.Responses[j].ID == messageID {
+				return service, &service.Responses[j]
+			}
+		}
+	}
+	return nil, nil
+}
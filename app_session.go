@@ -0,0 +1,50 @@
+package fastapi
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/funny/link"
+)
+
+// sessionInfo is the state App keeps for the sessions in handleSessoin.
+type sessionInfo struct {
+	mutex    sync.Mutex
+	identity *Identity
+	span     *Span
+}
+
+func (app *App) addSession(session *link.Session) *sessionInfo {
+	info := &sessionInfo{}
+	app.sessions.Store(session, info)
+	atomic.AddInt64(&app.metrics.sessions, 1)
+	return info
+}
+
+func (app *App) delSession(session *link.Session) {
+	app.sessions.Delete(session)
+	atomic.AddInt64(&app.metrics.sessions, -1)
+}
+
+// addRequest keeps the state of the session created for a gateway request,
+// it's not an active session, so it isn't counted by the metrics or listed
+// by the AdminHandler.
+func (app *App) addRequest(session *link.Session) *sessionInfo {
+	info := &sessionInfo{}
+	app.requests.Store(session, info)
+	return info
+}
+
+func (app *App) delRequest(session *link.Session) {
+	app.requests.Delete(session)
+}
+
+func (app *App) sessionInfo(session *link.Session) *sessionInfo {
+	if info, ok := app.sessions.Load(session); ok {
+		return info.(*sessionInfo)
+	}
+	if info, ok := app.requests.Load(session); ok {
+		return info.(*sessionInfo)
+	}
+	return nil
+}
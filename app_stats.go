@@ -0,0 +1,52 @@
+package fastapi
+
+import "sync/atomic"
+
+type Stats struct {
+	SendSizeErrors uint64
+	MarshalErrors  uint64
+
+	SendBlocked uint64
+	SendDropped uint64
+	SendKicked  uint64
+
+	RateLimited     uint64
+	RateLimitKicked uint64
+
+	RejectedMaxSessions uint64
+	RejectedPerIP       uint64
+	RejectedAcceptRate  uint64
+
+	AuthFailures uint64
+	AccessDenied uint64
+
+	// Average batch size is SendBatchPackets / SendBatches.
+	SendBatches      uint64
+	SendBatchPackets uint64
+	SendBatchBytes   uint64
+}
+
+func (app *App) Stats() Stats {
+	return Stats{
+		SendSizeErrors: atomic.LoadUint64(&app.stats.SendSizeErrors),
+		MarshalErrors:  atomic.LoadUint64(&app.stats.MarshalErrors),
+
+		SendBlocked: atomic.LoadUint64(&app.stats.SendBlocked),
+		SendDropped: atomic.LoadUint64(&app.stats.SendDropped),
+		SendKicked:  atomic.LoadUint64(&app.stats.SendKicked),
+
+		RateLimited:     atomic.LoadUint64(&app.stats.RateLimited),
+		RateLimitKicked: atomic.LoadUint64(&app.stats.RateLimitKicked),
+
+		RejectedMaxSessions: atomic.LoadUint64(&app.stats.RejectedMaxSessions),
+		RejectedPerIP:       atomic.LoadUint64(&app.stats.RejectedPerIP),
+		RejectedAcceptRate:  atomic.LoadUint64(&app.stats.RejectedAcceptRate),
+
+		AuthFailures: atomic.LoadUint64(&app.stats.AuthFailures),
+		AccessDenied: atomic.LoadUint64(&app.stats.AccessDenied),
+
+		SendBatches:      atomic.LoadUint64(&app.stats.SendBatches),
+		SendBatchPackets: atomic.LoadUint64(&app.stats.SendBatchPackets),
+		SendBatchBytes:   atomic.LoadUint64(&app.stats.SendBatchBytes),
+	}
+}
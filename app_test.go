@@ -0,0 +1,64 @@
+package fastapi
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/funny/link"
+)
+
+type testService struct{}
+
+func (s *testService) APIs() APIs {
+	return APIs{1: {testEcho{}, testEcho{}}}
+}
+
+func (s *testService) ServiceID() uint16 {
+	return 1
+}
+
+func (s *testService) NewRequest(id uint16) Message {
+	if id == 1 {
+		return &testEcho{}
+	}
+	return nil
+}
+
+func (s *testService) NewResponse(id uint16) Message {
+	return s.NewRequest(id)
+}
+
+func (s *testService) HandleRequest(session *link.Session, req Message) {
+	Send(session, req)
+}
+
+type testEcho struct {
+	Data []byte
+}
+
+func (m *testEcho) ServiceID() uint16        { return 1 }
+func (m *testEcho) MessageID() uint16        { return 1 }
+func (m *testEcho) Identity() string         { return "testService.testEcho" }
+func (m *testEcho) BinarySize() int          { return len(m.Data) }
+func (m *testEcho) MarshalPacket(p []byte)   { copy(p, m.Data) }
+func (m *testEcho) UnmarshalPacket(p []byte) { m.Data = append([]byte(nil), p...) }
+
+func newTestApp() *App {
+	app := New()
+	app.Register(1, &testService{})
+	return app
+}
+
+func testRoundTrip(t *testing.T, session *link.Session, data []byte) {
+	t.Helper()
+	if err := session.Send(&testEcho{data}); err != nil {
+		t.Fatalf("send failed: %s", err)
+	}
+	rsp, err := session.Receive()
+	if err != nil {
+		t.Fatalf("receive failed: %s", err)
+	}
+	if !bytes.Equal(rsp.(*testEcho).Data, data) {
+		t.Fatalf("response mismatch: %d bytes, expected %d bytes", len(rsp.(*testEcho).Data), len(data))
+	}
+}
@@ -0,0 +1,229 @@
+package fastapi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+)
+
+const traceSize = 16 + 8
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (id TraceID) IsZero() bool { return id == TraceID{} }
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+func (id SpanID) IsZero() bool { return id == SpanID{} }
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanContext is carried in the packet header when HeaderFormat.Trace is
+// enabled, it identifies the span of the sender.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+func (sc SpanContext) IsValid() bool {
+	return !sc.TraceID.IsZero() && !sc.SpanID.IsZero()
+}
+
+// StartTrace creates the root span context of a new trace, it's used by the
+// client to trace the requests sent by SendTraced().
+func StartTrace() SpanContext {
+	var sc SpanContext
+	rand.Read(sc.TraceID[:])
+	rand.Read(sc.SpanID[:])
+	return sc
+}
+
+// Span is created for every request handled by App when the header has trace
+// fields, it's exported by App.SpanExporter after the handler returned.
+type Span struct {
+	SpanContext
+	ParentID SpanID
+	Name     string
+	Service  string
+	Session  uint64
+	Start    time.Time
+	Duration time.Duration
+	Failed   bool
+}
+
+type SpanExporter interface {
+	ExportSpan(*Span)
+}
+
+type SpanExporterFunc func(*Span)
+
+func (f SpanExporterFunc) ExportSpan(span *Span) {
+	f(span)
+}
+
+type writerExporter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+// NewWriterExporter writes spans to w in JSON lines, it's useful for testing
+// with os.Stdout or a local file.
+func NewWriterExporter(w io.Writer) SpanExporter {
+	return &writerExporter{w: w}
+}
+
+func (e *writerExporter) ExportSpan(span *Span) {
+	line, _ := json.Marshal(struct {
+		TraceID  string  `json:"trace_id"`
+		SpanID   string  `json:"span_id"`
+		ParentID string  `json:"parent_id,omitempty"`
+		Name     string  `json:"name"`
+		Service  string  `json:"service"`
+		Session  uint64  `json:"session"`
+		Start    string  `json:"start"`
+		Duration float64 `json:"duration_ms"`
+		Failed   bool    `json:"failed,omitempty"`
+	}{
+		span.TraceID.String(),
+		span.SpanID.String(),
+		parentString(span.ParentID),
+		span.Name,
+		span.Service,
+		span.Session,
+		span.Start.Format(time.RFC3339Nano),
+		float64(span.Duration) / float64(time.Millisecond),
+		span.Failed,
+	})
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.w.Write(append(line, '\n'))
+}
+
+func parentString(id SpanID) string {
+	if id.IsZero() {
+		return ""
+	}
+	return id.String()
+}
+
+// Untraced unwraps the message received from fastway session when the Header
+// has trace fields, the traced messages are wrapped by Receive() to carry the
+// span context.
+func Untraced(msg interface{}) (Message, SpanContext) {
+	if t, ok := msg.(tracedMessage); ok {
+		return t.Message, t.trace
+	}
+	return msg.(Message), SpanContext{}
+}
+
+// recvTrace unwraps the span context of the received message, which is
+// carried by the packet header, or the fastway frame.
+func recvTrace(session *link.Session, msg Message) (Message, SpanContext) {
+	if t, ok := msg.(tracedMessage); ok {
+		return t.Message, t.trace
+	}
+	if c, ok := session.Codec().(*codec); ok {
+		return msg, c.head.Trace
+	}
+	return msg, SpanContext{}
+}
+
+// startSpan continues the trace of parent, or starts a new trace when the
+// request doesn't have one. Messages sent by the session during the handler
+// running carry the new span as their parent.
+func (app *App) startSpan(session *link.Session, req Message, parent SpanContext) *Span {
+	if !app.Header.Trace {
+		return nil
+	}
+	info := app.sessionInfo(session)
+	if info == nil {
+		return nil
+	}
+	span := &Span{
+		Name:    req.Identity(),
+		Service: app.serviceName(req.ServiceID()),
+		Session: session.ID(),
+		Start:   time.Now(),
+	}
+	if parent.IsValid() {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+	} else {
+		rand.Read(span.TraceID[:])
+	}
+	rand.Read(span.SpanID[:])
+	info.setSpan(span)
+	return span
+}
+
+func (app *App) endSpan(session *link.Session, span *Span, failed bool) {
+	if span == nil {
+		return
+	}
+	if info := app.sessionInfo(session); info != nil {
+		info.setSpan(nil)
+	}
+	span.Duration = time.Since(span.Start)
+	span.Failed = failed
+	if app.SpanExporter != nil {
+		app.SpanExporter.ExportSpan(span)
+	}
+}
+
+func (info *sessionInfo) setSpan(span *Span) {
+	info.mutex.Lock()
+	defer info.mutex.Unlock()
+	info.span = span
+}
+
+func (app *App) currentTrace(session *link.Session) (sc SpanContext) {
+	if span := app.CurrentSpan(session); span != nil {
+		sc = span.SpanContext
+	}
+	return
+}
+
+// CurrentSpan returns the span of the request being handled by the session,
+// it's nil when the header format doesn't have trace fields.
+func (app *App) CurrentSpan(session *link.Session) *Span {
+	if info := app.sessionInfo(session); info != nil {
+		info.mutex.Lock()
+		defer info.mutex.Unlock()
+		return info.span
+	}
+	return nil
+}
+
+// RecvTrace returns the span context carried by the last packet received by
+// the session.
+func RecvTrace(session *link.Session) SpanContext {
+	if c, ok := session.Codec().(*codec); ok {
+		return c.head.Trace
+	}
+	return SpanContext{}
+}
+
+type tracedMessage struct {
+	Message
+	trace SpanContext
+}
+
+// SendTraced sends the message with the given span context as its parent,
+// handlers propagate the trace to outbound calls by:
+//
+//	if span := app.CurrentSpan(session); span != nil {
+//		fastapi.SendTraced(backend, req, span.SpanContext)
+//	}
+//
+// Fastway sessions don't have the current span of the handler, the responses
+// have to be sent by SendTraced() to carry the trace.
+func SendTraced(session *link.Session, msg Message, parent SpanContext) error {
+	return session.Send(tracedMessage{msg, parent})
+}
@@ -0,0 +1,107 @@
+package fastapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+)
+
+func newTraceApps(t *testing.T) (*link.Session, chan *Span) {
+	spans := make(chan *Span, 10)
+	server := newTestApp()
+	server.Header.Trace = true
+	server.SpanExporter = SpanExporterFunc(func(span *Span) {
+		spans <- span
+	})
+	client := newTestApp()
+	client.Header.Trace = true
+
+	listener := ListenPipe()
+	go server.NewServer(listener, nil).Serve()
+
+	session, err := client.DialPipe(listener)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session, spans
+}
+
+func testExportedSpan(t *testing.T, spans chan *Span) *Span {
+	t.Helper()
+	select {
+	case span := <-spans:
+		if span.Name != "testService.testEcho" || span.Service != "testService" || span.Failed {
+			t.Fatalf("unexpected span: %+v", span)
+		}
+		if !span.IsValid() {
+			t.Fatal("invalid span context")
+		}
+		return span
+	case <-time.After(time.Second):
+		t.Fatal("span not exported")
+	}
+	return nil
+}
+
+func TestTracePropagation(t *testing.T) {
+	session, spans := newTraceApps(t)
+	defer session.Close()
+
+	root := StartTrace()
+	if err := SendTraced(session, &testEcho{[]byte("hello")}, root); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.Receive(); err != nil {
+		t.Fatal(err)
+	}
+	span := testExportedSpan(t, spans)
+	if span.TraceID != root.TraceID || span.ParentID != root.SpanID {
+		t.Fatalf("trace not continued: %+v", span)
+	}
+	if span.SpanID == root.SpanID {
+		t.Fatal("span id not generated")
+	}
+	// The response sent by the handler carries the span of the request.
+	if trace := RecvTrace(session); trace != span.SpanContext {
+		t.Fatalf("response trace %v, expect %v", trace, span.SpanContext)
+	}
+}
+
+func TestTraceRoot(t *testing.T) {
+	session, spans := newTraceApps(t)
+	defer session.Close()
+
+	testRoundTrip(t, session, []byte("hello"))
+	span := testExportedSpan(t, spans)
+	if !span.ParentID.IsZero() {
+		t.Fatalf("untraced request has parent: %+v", span)
+	}
+	if trace := RecvTrace(session); trace != span.SpanContext {
+		t.Fatalf("response trace %v, expect %v", trace, span.SpanContext)
+	}
+}
+
+func TestWriterExporter(t *testing.T) {
+	var buf bytes.Buffer
+	sc := StartTrace()
+	NewWriterExporter(&buf).ExportSpan(&Span{
+		SpanContext: sc,
+		Name:        "testService.testEcho",
+		Service:     "testService",
+		Duration:    1500 * time.Microsecond,
+	})
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line["trace_id"] != sc.TraceID.String() || line["span_id"] != sc.SpanID.String() ||
+		line["duration_ms"] != 1.5 || line["name"] != "testService.testEcho" {
+		t.Fatalf("unexpected line: %s", buf.String())
+	}
+	if _, ok := line["parent_id"]; ok {
+		t.Fatalf("root span has parent_id: %s", buf.String())
+	}
+}
@@ -0,0 +1,155 @@
+// Command fastapi-call sends messages to a fastapi server from terminal, the
+// messages are described by the schema exported by App.ExportSchema():
+//
+//	fastapi-call -schema app.json -addr 127.0.0.1:10010 Service.AddReq '{"A":1,"B":2}'
+//
+// Without message argument, it reads "Service.Message {json}" lines from
+// stdin. Responses and pushes are printed as "Service.Message {json}".
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/funny/fastapi"
+	"github.com/funny/link"
+)
+
+var (
+	schemaFile = flag.String("schema", "", "schema file exported by App.ExportSchema()")
+	network    = flag.String("network", "tcp", "network of server, tcp or unix")
+	address    = flag.String("addr", "127.0.0.1:10010", "address of server")
+	wait       = flag.Duration("wait", time.Second, "time to wait for responses before exit")
+)
+
+func main() {
+	flag.Parse()
+	log.SetFlags(0)
+
+	if *schemaFile == "" {
+		log.Fatal("missing -schema")
+	}
+	file, err := os.Open(*schemaFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	schema, err := fastapi.LoadSchema(file)
+	file.Close()
+	if err != nil {
+		log.Fatalf("load schema failed: %s", err)
+	}
+
+	app, err := schema.NewApp()
+	if err != nil {
+		log.Fatalf("bad schema: %s", err)
+	}
+	conn, err := net.Dial(*network, *address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	session, err := app.NewRawClient(conn)
+	if err != nil {
+		log.Fatalf("connect failed: %s", err)
+	}
+
+	// The session closed by main is not an error of recvLoop.
+	closing := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		recvLoop(schema, session, closing)
+	}()
+	defer func() {
+		close(closing)
+		session.Close()
+		<-done
+	}()
+
+	if flag.NArg() > 0 {
+		if err := send(schema, session, flag.Arg(0), strings.Join(flag.Args()[1:], " ")); err != nil {
+			log.Fatal(err)
+		}
+		time.Sleep(*wait)
+		return
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, args := line, ""
+		if i := strings.IndexAny(line, " \t"); i > 0 {
+			name, args = line[:i], line[i+1:]
+		}
+		if err := send(schema, session, name, args); err != nil {
+			log.Print(err)
+		}
+	}
+	time.Sleep(*wait)
+}
+
+func send(schema *fastapi.Schema, session *link.Session, name, args string) error {
+	service, message := schema.Request(name)
+	if message == nil {
+		return fmt.Errorf("unknown request %q", name)
+	}
+
+	var v interface{}
+	if args = strings.TrimSpace(args); args != "" {
+		decoder := json.NewDecoder(strings.NewReader(args))
+		decoder.UseNumber()
+		if err := decoder.Decode(&v); err != nil {
+			return fmt.Errorf("bad json of %s: %s", name, err)
+		}
+	}
+
+	payload, err := message.Type.Encode(v)
+	if err != nil {
+		return fmt.Errorf("encode %s failed: %s", name, err)
+	}
+	return session.Send(&fastapi.RawMessage{
+		Service: service.ID,
+		Message: message.ID,
+		Payload: payload,
+	})
+}
+
+func recvLoop(schema *fastapi.Schema, session *link.Session, closing chan struct{}) {
+	for {
+		msg, err := session.Receive()
+		if err != nil {
+			select {
+			case <-closing:
+				return
+			default:
+			}
+			log.Printf("session closed: %s", err)
+			os.Exit(1)
+		}
+		raw := msg.(*fastapi.RawMessage)
+
+		service, message := schema.Response(raw.Service, raw.Message)
+		if message == nil {
+			fmt.Printf("[%d, %d] %s\n", raw.Service, raw.Message, hex.EncodeToString(raw.Payload))
+			continue
+		}
+		v, err := message.Type.Decode(raw.Payload)
+		if err != nil {
+			fmt.Printf("%s.%s decode failed: %s\n", service.Name, message.Name, err)
+			continue
+		}
+		data, _ := json.Marshal(v)
+		fmt.Printf("%s.%s %s\n", service.Name, message.Name, data)
+	}
+}
@@ -9,17 +9,17 @@ import (
 	"github.com/funny/link"
 )
 
-func (_ *Service) ServiceID() byte {
+func (_ *Service) ServiceID() uint16 {
 	return 1
 }
-func (_ *Service) NewRequest(id byte) fastapi.Message {
+func (_ *Service) NewRequest(id uint16) fastapi.Message {
 	switch id {
 	case 1:
 		return &AddReq{}
 	}
 	return nil
 }
-func (_ *Service) NewResponse(id byte) fastapi.Message {
+func (_ *Service) NewResponse(id uint16) fastapi.Message {
 	switch id {
 	case 1:
 		return &AddRsp{}
@@ -29,26 +29,39 @@ func (_ *Service) NewResponse(id byte) fastapi.Message {
 func (s *Service) HandleRequest(session *link.Session, req fastapi.Message) {
 	switch req.MessageID() {
 	case 1:
-		session.Send(s.Add(session, req.(*AddReq)))
+		fastapi.Send(session, s.Add(session, req.(*AddReq)))
 	default:
 		panic("Unhandled Message Type")
 	}
 }
-func (this *AddReq) ServiceID() byte {
+func (this *AddReq) ServiceID() uint16 {
 	return 1
 }
-func (this *AddReq) MessageID() byte {
+func (this *AddReq) MessageID() uint16 {
 	return 1
 }
 func (this *AddReq) Identity() string {
 	return "Service.AddReq"
 }
-func (this *AddRsp) ServiceID() byte {
+func (this *AddReq) Dump() string {
+	var d fastapi.DumpBuilder
+	d.Begin(this.Identity())
+	d.Field("A", this.A)
+	d.Field("B", this.B)
+	return d.End()
+}
+func (this *AddRsp) ServiceID() uint16 {
 	return 1
 }
-func (this *AddRsp) MessageID() byte {
+func (this *AddRsp) MessageID() uint16 {
 	return 1
 }
 func (this *AddRsp) Identity() string {
 	return "Service.AddRsp"
 }
+func (this *AddRsp) Dump() string {
+	var d fastapi.DumpBuilder
+	d.Begin(this.Identity())
+	d.Field("C", this.C)
+	return d.End()
+}
@@ -10,16 +10,41 @@ import (
 
 var sessionType = reflect.TypeOf((*link.Session)(nil))
 
-type APIs map[byte][2]interface{}
+type APIs map[uint16][2]interface{}
 
 type Provider interface {
 	APIs() APIs
 }
 
-func (app *App) Register(id byte, service Provider) {
+// MessageOption sets the options of message type declared in APIs:
+//
+//	fastapi.APIs{
+//		1: {AddReq{}, fastapi.With(AddRsp{}, fastapi.SetPriority(fastapi.PriorityHigh))},
+//	}
+type MessageOption func(*MessageType)
+
+type apiMessage struct {
+	msg  interface{}
+	opts []MessageOption
+}
+
+func With(msg interface{}, opts ...MessageOption) interface{} {
+	return apiMessage{msg, opts}
+}
+
+func unwrapMessage(msg interface{}) (interface{}, []MessageOption) {
+	if m, ok := msg.(apiMessage); ok {
+		return m.msg, m.opts
+	}
+	return msg, nil
+}
+
+type ServiceOption func(*ServiceType)
+
+func (app *App) Register(id uint16, service Provider, opts ...ServiceOption) {
 	typeOfService := reflect.TypeOf(service)
 
-	if app.services[id] != nil {
+	if int(id) < len(app.services) && app.services[id] != nil {
 		panic(fmt.Sprintf("duplicate service id '%d' for '%s' and '%s'", id, typeOfService, app.services[id]))
 	}
 
@@ -29,12 +54,20 @@ func (app *App) Register(id byte, service Provider) {
 		}
 	}
 
+	if int(id) >= len(app.services) {
+		services := make([]Provider, int(id)+1)
+		copy(services, app.services)
+		app.services = services
+	}
 	app.services[id] = service
 
 	serviceType := &ServiceType{
 		id: id,
 		t:  typeOfService,
 	}
+	for _, opt := range opts {
+		opt(serviceType)
+	}
 
 	for id, api := range service.APIs() {
 		if api[0] != nil {
@@ -45,6 +78,19 @@ func (app *App) Register(id byte, service Provider) {
 		}
 	}
 
+	for _, req := range serviceType.requests {
+		rule := req.access
+		if rule == nil {
+			rule = serviceType.access
+		}
+		if rule != nil {
+			if app.accessRules == nil {
+				app.accessRules = make(map[uint32]*AccessRule)
+			}
+			app.accessRules[uint32(serviceType.id)<<16|uint32(req.id)] = rule
+		}
+	}
+
 	app.serviceTypes = append(app.serviceTypes, serviceType)
 }
 
@@ -53,14 +99,16 @@ func (app *App) ServiceTypes() []*ServiceType {
 }
 
 type ServiceType struct {
-	id        byte
+	id        uint16
 	t         reflect.Type
 	requests  []*MessageType
 	responses []*MessageType
 	handlers  []*HandlerMethod
+	access    *AccessRule
 }
 
-func (service *ServiceType) registerReq(id byte, req interface{}) {
+func (service *ServiceType) registerReq(id uint16, req interface{}) {
+	req, opts := unwrapMessage(req)
 	reqType := reflect.TypeOf(req)
 	if reqType.Kind() == reflect.Ptr {
 		reqType = reqType.Elem()
@@ -72,11 +120,15 @@ func (service *ServiceType) registerReq(id byte, req interface{}) {
 		}
 	}
 
-	service.requests = append(service.requests, &MessageType{
+	reqMsg := &MessageType{
 		service: service,
 		id:      id,
 		t:       reqType,
-	})
+	}
+	for _, opt := range opts {
+		opt(reqMsg)
+	}
+	service.requests = append(service.requests, reqMsg)
 
 	// Search Request Handler:
 	//
@@ -100,6 +152,19 @@ func (service *ServiceType) registerReq(id byte, req interface{}) {
 			continue
 		}
 
+		// The custom session type is created by App.SessionFactory and
+		// stored in link.Session.State.
+		needSession := method.Type.NumIn() == 3
+		var sessType reflect.Type
+		if needSession {
+			if arg := method.Type.In(1); arg != sessionType {
+				if arg.Kind() != reflect.Ptr {
+					continue
+				}
+				sessType = arg.Elem()
+			}
+		}
+
 		var rspType reflect.Type
 		if method.Type.NumOut() == 1 {
 			rspType = method.Type.Out(0)
@@ -110,13 +175,16 @@ func (service *ServiceType) registerReq(id byte, req interface{}) {
 			Name:        method.Name,
 			ReqType:     reqType,
 			RspType:     rspType,
-			NeedSession: method.Type.In(1) == sessionType,
+			NeedSession: needSession,
+			SessionType: sessType,
+			pkgPath:     service.t.Elem().PkgPath(),
 		})
 		break
 	}
 }
 
-func (service *ServiceType) registerRsp(id byte, rsp interface{}) {
+func (service *ServiceType) registerRsp(id uint16, rsp interface{}) {
+	rsp, opts := unwrapMessage(rsp)
 	rspType := reflect.TypeOf(rsp)
 	if rspType.Kind() == reflect.Ptr {
 		rspType = rspType.Elem()
@@ -128,14 +196,18 @@ func (service *ServiceType) registerRsp(id byte, rsp interface{}) {
 		}
 	}
 
-	service.responses = append(service.responses, &MessageType{
+	rspMsg := &MessageType{
 		service: service,
 		id:      id,
 		t:       rspType,
-	})
+	}
+	for _, opt := range opts {
+		opt(rspMsg)
+	}
+	service.responses = append(service.responses, rspMsg)
 }
 
-func (service *ServiceType) ID() byte {
+func (service *ServiceType) ID() uint16 {
 	return service.id
 }
 
@@ -164,16 +236,18 @@ func (service *ServiceType) Handlers() []*HandlerMethod {
 }
 
 type MessageType struct {
-	service *ServiceType
-	id      byte
-	t       reflect.Type
+	service  *ServiceType
+	id       uint16
+	t        reflect.Type
+	priority Priority
+	access   *AccessRule
 }
 
 func (msg *MessageType) Service() *ServiceType {
 	return msg.service
 }
 
-func (msg *MessageType) ID() byte {
+func (msg *MessageType) ID() uint16 {
 	return msg.id
 }
 
@@ -189,12 +263,41 @@ func (msg *MessageType) Name() string {
 	return msg.t.Name()
 }
 
+// Fields returns the exported field names of message, they're dumped by the
+// generated Dump() method.
+func (msg *MessageType) Fields() []string {
+	var fields []string
+	for i := 0; i < msg.t.NumField(); i++ {
+		if field := msg.t.Field(i); field.PkgPath == "" {
+			fields = append(fields, field.Name)
+		}
+	}
+	return fields
+}
+
+func (msg *MessageType) Priority() Priority {
+	return msg.priority
+}
+
 type HandlerMethod struct {
-	ID          byte
+	ID          uint16
 	Name        string
 	ReqType     reflect.Type
 	RspType     reflect.Type
 	NeedSession bool
+	SessionType reflect.Type
+	pkgPath     string
+}
+
+func (h *HandlerMethod) sessionCode() string {
+	if h.SessionType == nil {
+		return "session"
+	}
+	name := h.SessionType.String()
+	if h.SessionType.PkgPath() == h.pkgPath {
+		name = h.SessionType.Name()
+	}
+	return fmt.Sprintf("session.State.(*%s)", name)
 }
 
 func (h *HandlerMethod) InvokeCode() string {
@@ -202,11 +305,11 @@ func (h *HandlerMethod) InvokeCode() string {
 		if h.RspType == nil {
 			return fmt.Sprintf("s.%s(req.(*%s))", h.Name, h.ReqType.Name())
 		}
-		return fmt.Sprintf("session.Send(s.%s(req.(*%s)))", h.Name, h.ReqType.Name())
+		return fmt.Sprintf("fastapi.Send(session, s.%s(req.(*%s)))", h.Name, h.ReqType.Name())
 	}
 
 	if h.RspType == nil {
-		return fmt.Sprintf("s.%s(session, req.(*%s))", h.Name, h.ReqType.Name())
+		return fmt.Sprintf("s.%s(%s, req.(*%s))", h.Name, h.sessionCode(), h.ReqType.Name())
 	}
-	return fmt.Sprintf("session.Send(s.%s(session, req.(*%s)))", h.Name, h.ReqType.Name())
+	return fmt.Sprintf("fastapi.Send(session, s.%s(%s, req.(*%s)))", h.Name, h.sessionCode(), h.ReqType.Name())
 }
@@ -28,6 +28,10 @@ func packages(apps []*App) map[string]*packageInfo {
 			for _, message := range serviceType.responses {
 				pkg.AddMessage(message)
 			}
+
+			for _, handler := range serviceType.handlers {
+				pkg.Import(handler.SessionType)
+			}
 		}
 	}
 
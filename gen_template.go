@@ -7,15 +7,15 @@ import (
 )
 
 type Service interface {
-	ServiceID() byte
-	NewRequest(byte) Message
-	NewResponse(byte) Message
+	ServiceID() uint16
+	NewRequest(uint16) Message
+	NewResponse(uint16) Message
 	HandleRequest(*link.Session, Message)
 }
 
 type Message interface {
-	ServiceID() byte
-	MessageID() byte
+	ServiceID() uint16
+	MessageID() uint16
 	Identity() string
 	BinarySize() int
 	MarshalPacket([]byte)
@@ -30,6 +30,29 @@ func (encodeError EncodeError) Error() string {
 	return fmt.Sprintf("Encode Error: %v", encodeError.Message)
 }
 
+// SizeError and MarshalError are returned by sending the message can't be
+// encoded. link.Session.Send() closes the session on any error returned by
+// codec, use Send() to drop the message and keep the session open.
+type SizeError struct {
+	Identity string
+	Size     int
+	MaxSize  int
+}
+
+func (sizeError SizeError) Error() string {
+	return fmt.Sprintf("Too Large Message Size: '%s' %d > %d", sizeError.Identity, sizeError.Size, sizeError.MaxSize)
+}
+
+type MarshalError struct {
+	Identity string
+	Size     int
+	Panic    interface{}
+}
+
+func (marshalError MarshalError) Error() string {
+	return fmt.Sprintf("Marshal Error: '%s' size %d - %v", marshalError.Identity, marshalError.Size, marshalError.Panic)
+}
+
 type DecodeError struct {
 	Message interface{}
 }
@@ -56,11 +79,11 @@ import {{.Name}} "{{.Path}}"
 
 {{range .Services}}
 
-func (_ *{{.Name}}) ServiceID() byte {
+func (_ *{{.Name}}) ServiceID() uint16 {
 	return {{.ID}}
 }
 
-func (_ *{{.Name}}) NewRequest(id byte) (fastapi.Message) {
+func (_ *{{.Name}}) NewRequest(id uint16) (fastapi.Message) {
 	switch id {
 	{{range .Requests}}
 	case {{.ID}}:
@@ -70,7 +93,7 @@ func (_ *{{.Name}}) NewRequest(id byte) (fastapi.Message) {
 	return nil
 }
 
-func (_ *{{.Name}}) NewResponse(id byte) (fastapi.Message) {
+func (_ *{{.Name}}) NewResponse(id uint16) (fastapi.Message) {
 	switch id {
 	{{range .Responses}}
 	case {{.ID}}:
@@ -93,16 +116,28 @@ func (s *{{.Name}}) HandleRequest(session *link.Session, req fastapi.Message) {
 {{end}}
 
 {{range .Messages}}
-func (this *{{.Name}}) ServiceID() byte {
+func (this *{{.Name}}) ServiceID() uint16 {
 	return {{.Service.ID}}
 }
 
-func (this *{{.Name}}) MessageID() byte {
+func (this *{{.Name}}) MessageID() uint16 {
 	return {{.ID}}
 }
 
 func (this *{{.Name}}) Identity() string {
 	return "{{.Service.Name}}.{{.Name}}"
 }
+
+func (this *{{.Name}}) Dump() string {
+	var d fastapi.DumpBuilder
+	d.Begin(this.Identity())
+	{{range .Fields}}d.Field("{{.}}", this.{{.}})
+	{{end}}return d.End()
+}
+{{if .Priority}}
+func (this *{{.Name}}) Priority() fastapi.Priority {
+	return {{.Priority}}
+}
+{{end}}
 {{end}}
 `